@@ -11,8 +11,10 @@ import (
 func MigrateDB(db *gorm.DB) error {
 	log.Println("Running database migrations...")
 
-	// Migrate the Container model
-	if err := db.AutoMigrate(&models.Container{}); err != nil {
+	// Migrate every model. Stacks go before the containers that reference
+	// them through a foreign key, and users before their sessions, API
+	// tokens and stack grants.
+	if err := db.AutoMigrate(&models.Stack{}, &models.Container{}, &models.StackRevision{}, &models.LogEntry{}, &models.ExecSession{}, &models.User{}, &models.Session{}, &models.APIToken{}, &models.StackGrant{}, &models.AuditEvent{}); err != nil {
 		return err
 	}
 
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// APIToken is a bearer token for the API. Only a hash of the token is
+// stored, the token itself is shown once when it is created.
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey;autoIncrement"`
+	Name       string     `gorm:"column:name;not null"`
+	TokenHash  string     `gorm:"column:token_hash;uniqueIndex;not null"`
+	UserID     uint       `gorm:"column:user_id;not null;index"`
+	User       User       `gorm:"constraint:OnDelete:CASCADE"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`
+	CreatedAt  time.Time  `gorm:"column:created_at;not null"`
+}
+
+// TableName specifies the table name for the APIToken model
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// String returns a string representation of the APIToken
+func (t APIToken) String() string {
+	return fmt.Sprintf("APIToken{ID: %d, Name: %s, UserID: %d}", t.ID, t.Name, t.UserID)
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// AuditOutcome represents whether an audited request was carried out
+type AuditOutcome string
+
+// Audit outcomes as enum values
+const (
+	AuditSucceeded AuditOutcome = "succeeded"
+	AuditFailed    AuditOutcome = "failed"
+	AuditDenied    AuditOutcome = "denied"
+)
+
+// AuditEvent records a state-changing or denied request and who made it.
+// PayloadDigest is the SHA-256 of the request body, so the exact YAML or
+// JSON sent can be matched later without storing it.
+type AuditEvent struct {
+	ID            uint         `gorm:"primaryKey;autoIncrement"`
+	Username      string       `gorm:"column:username;not null;index"`
+	ClientIP      string       `gorm:"column:client_ip;not null"`
+	Action        string       `gorm:"column:action;not null;index"`
+	Method        string       `gorm:"column:method;not null"`
+	Path          string       `gorm:"column:path;not null"`
+	Container     string       `gorm:"column:container;index"`
+	Stack         string       `gorm:"column:stack;index"`
+	PayloadDigest string       `gorm:"column:payload_digest"`
+	Status        int          `gorm:"column:status;not null;default:0"`
+	Outcome       AuditOutcome `gorm:"column:outcome;type:varchar(20);not null;index"`
+	Error         string       `gorm:"column:error;type:text"`
+	CreatedAt     time.Time    `gorm:"column:created_at;not null;index"`
+}
+
+// TableName specifies the table name for the AuditEvent model
+func (AuditEvent) TableName() string {
+	return "audit_events"
+}
+
+// String returns a string representation of the AuditEvent
+func (e AuditEvent) String() string {
+	return fmt.Sprintf("AuditEvent{ID: %d, User: %s, Action: %s, Outcome: %s}", e.ID, e.Username, e.Action, e.Outcome)
+}
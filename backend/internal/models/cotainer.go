@@ -16,18 +16,38 @@ const (
 	StatusRestarting ContainerStatus = "restarting"
 	StatusPaused     ContainerStatus = "paused"
 	StatusExited     ContainerStatus = "exited"
+	StatusRemoved    ContainerStatus = "removed"
+)
+
+// HealthStatus defines the health states reported by a container's healthcheck
+type HealthStatus string
+
+// Health statuses as enum values
+const (
+	HealthNone      HealthStatus = "none"
+	HealthStarting  HealthStatus = "starting"
+	HealthHealthy   HealthStatus = "healthy"
+	HealthUnhealthy HealthStatus = "unhealthy"
 )
 
 // Container represents a container in the database.
 type Container struct {
-	ID          uint            `gorm:"primaryKey;autoIncrement"`
-	Name        string          `gorm:"column:name;not null"`
-	Image       string          `gorm:"column:image;not null"`
-	ContainerID string          `gorm:"column:container_id;not null"`
-	Ports       string          `gorm:"column:ports;not null"`
-	Status      ContainerStatus `gorm:"column:status;type:varchar(20);not null"`
-	CreatedAt   time.Time       `gorm:"column:created_at;not null"`
-	UpdatedAt   time.Time       `gorm:"column:updated_at;not null"`
+	ID           uint            `gorm:"primaryKey;autoIncrement"`
+	Name         string          `gorm:"column:name;not null"`
+	Image        string          `gorm:"column:image;not null"`
+	ContainerID  string          `gorm:"column:container_id;not null"`
+	StackID      *uint           `gorm:"column:stack_id;index"`
+	SpecHash     string          `gorm:"column:spec_hash"`
+	Ports        string          `gorm:"column:ports;not null"`
+	Status       ContainerStatus `gorm:"column:status;type:varchar(20);not null"`
+	Health       HealthStatus    `gorm:"column:health;type:varchar(20);not null;default:none"`
+	HealthOutput string          `gorm:"column:health_output;type:text"`
+	ExitCode     int             `gorm:"column:exit_code;not null;default:0"`
+	RestartCount int             `gorm:"column:restart_count;not null;default:0"`
+	StartedAt    *time.Time      `gorm:"column:started_at"`
+	FinishedAt   *time.Time      `gorm:"column:finished_at"`
+	CreatedAt    time.Time       `gorm:"column:created_at;not null"`
+	UpdatedAt    time.Time       `gorm:"column:updated_at;not null"`
 }
 
 // TableName specifies the table name for the Container model
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExecSession records an interactive terminal opened in a container
+type ExecSession struct {
+	ID            uint       `gorm:"primaryKey;autoIncrement"`
+	ContainerName string     `gorm:"column:container_name;not null;index"`
+	ExecID        string     `gorm:"column:exec_id;not null"`
+	User          string     `gorm:"column:username;not null"`
+	Command       string     `gorm:"column:command;not null"`
+	StartedAt     time.Time  `gorm:"column:started_at;not null"`
+	EndedAt       *time.Time `gorm:"column:ended_at"`
+	DurationMs    int64      `gorm:"column:duration_ms;not null;default:0"`
+	ExitCode      *int       `gorm:"column:exit_code"`
+}
+
+// TableName specifies the table name for the ExecSession model
+func (ExecSession) TableName() string {
+	return "exec_sessions"
+}
+
+// String returns a string representation of the ExecSession
+func (e ExecSession) String() string {
+	return fmt.Sprintf("ExecSession{ID: %d, Container: %s, User: %s, Command: %s}", e.ID, e.ContainerName, e.User, e.Command)
+}
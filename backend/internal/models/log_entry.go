@@ -0,0 +1,27 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// LogEntry is an archived line of container output. Entries are keyed by
+// container name rather than a foreign key so they outlive the container.
+type LogEntry struct {
+	ID            uint      `gorm:"primaryKey;autoIncrement"`
+	ContainerName string    `gorm:"column:container_name;not null;index:idx_log_container_time"`
+	ContainerID   string    `gorm:"column:container_id;not null"`
+	Stream        string    `gorm:"column:stream;type:varchar(10);not null"`
+	Timestamp     time.Time `gorm:"column:timestamp;not null;index;index:idx_log_container_time"`
+	Text          string    `gorm:"column:text;type:text;not null"`
+}
+
+// TableName specifies the table name for the LogEntry model
+func (LogEntry) TableName() string {
+	return "log_entries"
+}
+
+// String returns a string representation of the LogEntry
+func (l LogEntry) String() string {
+	return fmt.Sprintf("LogEntry{Container: %s, Stream: %s, Timestamp: %s}", l.ContainerName, l.Stream, l.Timestamp.Format(time.RFC3339))
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// RevisionResult represents the outcome of applying a stack revision
+type RevisionResult string
+
+// Revision results as enum values
+const (
+	RevisionApplied RevisionResult = "applied"
+	RevisionFailed  RevisionResult = "failed"
+)
+
+// StackRevision is an immutable record of a YAML file applied to a stack.
+// Revisions are keyed by stack name rather than ID so the history survives
+// the stack being deleted and recreated.
+type StackRevision struct {
+	ID         uint           `gorm:"primaryKey;autoIncrement"`
+	StackName  string         `gorm:"column:stack_name;not null;uniqueIndex:idx_stack_revision"`
+	Revision   int            `gorm:"column:revision;not null;uniqueIndex:idx_stack_revision"`
+	SourceYAML string         `gorm:"column:source_yaml;type:text;not null"`
+	Spec       string         `gorm:"column:spec;type:text;not null"`
+	Actor      string         `gorm:"column:actor;not null"`
+	RollbackOf *int           `gorm:"column:rollback_of"`
+	Result     RevisionResult `gorm:"column:result;type:varchar(20);not null"`
+	Error      string         `gorm:"column:error;type:text"`
+	CreatedAt  time.Time      `gorm:"column:created_at;not null"`
+}
+
+// TableName specifies the table name for the StackRevision model
+func (StackRevision) TableName() string {
+	return "stack_revisions"
+}
+
+// String returns a string representation of the StackRevision
+func (r StackRevision) String() string {
+	return fmt.Sprintf("StackRevision{Stack: %s, Revision: %d, Result: %s}", r.StackName, r.Revision, r.Result)
+}
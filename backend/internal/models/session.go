@@ -0,0 +1,29 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Session is a signed-in browser. Only a hash of the cookie value is stored.
+// CSRFToken must accompany every state-changing request made with the
+// session.
+type Session struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	TokenHash string    `gorm:"column:token_hash;uniqueIndex;not null"`
+	UserID    uint      `gorm:"column:user_id;not null;index"`
+	User      User      `gorm:"constraint:OnDelete:CASCADE"`
+	CSRFToken string    `gorm:"column:csrf_token;not null;default:''"`
+	ExpiresAt time.Time `gorm:"column:expires_at;not null;index"`
+	CreatedAt time.Time `gorm:"column:created_at;not null"`
+}
+
+// TableName specifies the table name for the Session model
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// String returns a string representation of the Session
+func (s Session) String() string {
+	return fmt.Sprintf("Session{ID: %d, UserID: %d, ExpiresAt: %s}", s.ID, s.UserID, s.ExpiresAt.Format(time.RFC3339))
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Stack represents a group of containers created from a single YAML upload.
+type Stack struct {
+	ID         uint        `gorm:"primaryKey;autoIncrement"`
+	Name       string      `gorm:"column:name;uniqueIndex;not null"`
+	SourceYAML string      `gorm:"column:source_yaml;type:text;not null"`
+	Revision   int         `gorm:"column:revision;not null;default:0"`
+	Containers []Container `gorm:"foreignKey:StackID;constraint:OnDelete:SET NULL"`
+	CreatedAt  time.Time   `gorm:"column:created_at;not null"`
+	UpdatedAt  time.Time   `gorm:"column:updated_at;not null"`
+}
+
+// TableName specifies the table name for the Stack model
+func (Stack) TableName() string {
+	return "stacks"
+}
+
+// String returns a string representation of the Stack
+func (s Stack) String() string {
+	return fmt.Sprintf("Stack{ID: %d, Name: %s, Revision: %d}", s.ID, s.Name, s.Revision)
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// StackGrant gives a user a role on a single stack on top of their own
+// role, e.g. an operator grant lets a viewer restart the containers of one
+// stack. Grants are keyed by stack name so they apply to stacks that don't
+// exist yet.
+type StackGrant struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	UserID    uint      `gorm:"column:user_id;not null;uniqueIndex:idx_stack_grant"`
+	User      User      `gorm:"constraint:OnDelete:CASCADE"`
+	StackName string    `gorm:"column:stack_name;not null;uniqueIndex:idx_stack_grant"`
+	Role      Role      `gorm:"column:role;type:varchar(20);not null"`
+	CreatedAt time.Time `gorm:"column:created_at;not null"`
+	UpdatedAt time.Time `gorm:"column:updated_at;not null"`
+}
+
+// TableName specifies the table name for the StackGrant model
+func (StackGrant) TableName() string {
+	return "stack_grants"
+}
+
+// String returns a string representation of the StackGrant
+func (g StackGrant) String() string {
+	return fmt.Sprintf("StackGrant{UserID: %d, Stack: %s, Role: %s}", g.UserID, g.StackName, g.Role)
+}
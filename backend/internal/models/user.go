@@ -0,0 +1,54 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Role defines what a user may do
+type Role string
+
+// Roles as enum values, from least to most privileged
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+// roleRanks orders the roles by privilege
+var roleRanks = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether r grants at least the privileges of required
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// User is an account that can sign in to the web UI and the API
+type User struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement"`
+	Username     string    `gorm:"column:username;uniqueIndex;not null"`
+	PasswordHash string    `gorm:"column:password_hash;not null"`
+	Role         Role      `gorm:"column:role;type:varchar(20);not null;default:viewer"`
+	CreatedAt    time.Time `gorm:"column:created_at;not null"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;not null"`
+}
+
+// TableName specifies the table name for the User model
+func (User) TableName() string {
+	return "users"
+}
+
+// String returns a string representation of the User
+func (u User) String() string {
+	return fmt.Sprintf("User{ID: %d, Username: %s, Role: %s}", u.ID, u.Username, u.Role)
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ApplyResult reports what applying a YAML file did to each container
+type ApplyResult struct {
+	Stack     string   `json:"stack"`
+	Revision  int      `json:"revision"`
+	Created   []string `json:"created"`
+	Recreated []string `json:"recreated"`
+	Unchanged []string `json:"unchanged"`
+	Pruned    []string `json:"pruned"`
+	Starting  []string `json:"starting"`
+}
+
+// NameConflict is a container name that belongs to another stack
+type NameConflict struct {
+	Container string `json:"container"`
+	Stack     string `json:"stack"`
+}
+
+// ConflictError is returned when a stack names containers that belong to
+// other stacks, which it must not take over
+type ConflictError struct {
+	Conflicts []NameConflict
+}
+
+// Error lists the conflicting containers with the stacks they belong to
+func (e *ConflictError) Error() string {
+	names := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		names[i] = fmt.Sprintf("'%s' belongs to stack '%s'", conflict.Container, conflict.Stack)
+	}
+	return "Container name conflict: " + strings.Join(names, ", ")
+}
+
+// errStackBusy is returned when a stack is applied while it is still being
+// started
+var errStackBusy = errors.New("Stack is still being started, try again once it is running")
+
+// applyMutex serializes applies so revisions are numbered in order and two
+// applies never touch the same containers at once
+var applyMutex sync.Mutex
+
+// applyOptions controls how a stack is applied and how the resulting
+// revision is recorded
+type applyOptions struct {
+	// Prune removes containers that are no longer in the YAML
+	Prune bool
+	// Actor identifies who requested the apply
+	Actor string
+	// RollbackOf is the revision being restored, or 0 for a regular apply
+	RollbackOf int
+}
+
+// API handlers
+func applyHandler(c *gin.Context) {
+	yamlData, defaultName, err := readYamlRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, err := parseContainersConfig(yamlData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, configErrorBody(err))
+		return
+	}
+
+	stackName := config.stackName(defaultName)
+	setAuditTarget(c, "", stackName)
+
+	result, err := applyStack(stackName, yamlData, config, applyOptions{
+		Prune: pruneRequested(c),
+		Actor: requestActor(c),
+	})
+	if err != nil {
+		c.JSON(applyErrorStatus(err), applyErrorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Helper functions
+
+// pruneRequested reports whether the request asked for containers missing
+// from the YAML to be removed, either as a query parameter or a form field
+func pruneRequested(c *gin.Context) bool {
+	return c.Query("prune") == "true" || c.PostForm("prune") == "true"
+}
+
+// applyErrorBody builds the response body for a failed apply, listing the
+// policy violations that blocked it or the changes that were rolled back
+func applyErrorBody(err error) gin.H {
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		return gin.H{
+			"error":      fmt.Sprintf("Rejected by policy: %d violation(s) found", len(policyErr.Violations)),
+			"violations": policyErr.Violations,
+		}
+	}
+	var conflictErr *ConflictError
+	if errors.As(err, &conflictErr) {
+		return gin.H{"error": conflictErr.Error(), "conflicts": conflictErr.Conflicts}
+	}
+
+	body := gin.H{"error": err.Error()}
+	var applyErr *ApplyError
+	if errors.As(err, &applyErr) {
+		body["rolled_back"] = applyErr.RolledBack
+	}
+	return body
+}
+
+// applyErrorStatus returns the HTTP status for a failed apply
+func applyErrorStatus(err error) int {
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		return http.StatusUnprocessableEntity
+	}
+	var conflictErr *ConflictError
+	if errors.As(err, &conflictErr) || errors.Is(err, errStackBusy) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// specHash returns a stable hash of a container configuration. Containers
+// whose hash is unchanged are left alone when a stack is re-applied.
+func specHash(config ContainerConfig) string {
+	// json.Marshal sorts map keys, so equal configs always hash the same
+	data, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// applyStack reconciles Docker with the containers described by config. Only
+// containers whose spec hash differs from the running one are recreated, and
+// when pruning containers that disappeared from the YAML are removed.
+// Stacks breaking an admission policy are rejected with a *PolicyError, and
+// stacks naming containers of other stacks with a *ConflictError, before
+// anything changes.
+// The apply is all-or-nothing: on failure the database changes are rolled
+// back and the previous containers restored, and an *ApplyError is returned.
+// Containers whose dependencies aren't ready yet, and the ones after them,
+// are started in the background once the apply is committed; meanwhile the
+// stack can't be applied again and errStackBusy is returned.
+// Every attempt that reaches Docker is recorded as a new revision of the
+// stack, whether it succeeds or fails. Attempts rejected before, by a
+// policy, a name conflict, a dependency error or a start in progress,
+// leave no revision.
+func applyStack(name string, yamlData []byte, config ContainersConfig, options applyOptions) (*ApplyResult, error) {
+	ctx := context.Background()
+
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+	start := time.Now()
+
+	if err := policies.check(config.Containers); err != nil {
+		return nil, err
+	}
+	if err := checkNameConflicts(ctx, name, config.Containers); err != nil {
+		return nil, err
+	}
+
+	// Create containers after the ones they depend on
+	ordered, err := orderContainers(config.Containers)
+	if err != nil {
+		return nil, err
+	}
+
+	// Containers left waiting for their dependencies are started by a
+	// background action of the stack, which must not run twice at once
+	if !reserveStackAction(name) {
+		return nil, errStackBusy
+	}
+	starting := false
+	defer func() {
+		if !starting {
+			releaseStackAction(name)
+		}
+	}()
+
+	revision, err := nextRevision(name)
+	if err != nil {
+		return nil, err
+	}
+
+	deploy := &deployment{}
+	var result *ApplyResult
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = deployStack(ctx, tx, deploy, name, revision, yamlData, config, ordered, options.Prune)
+		return err
+	})
+	if err != nil {
+		rolledBack := deploy.rollback(ctx)
+		log.Printf("Applying stack %s failed, rolled back %d change(s): %v", name, len(rolledBack), err)
+		recordRevision(name, revision, yamlData, config, options, err)
+		applyDuration.observe(time.Since(start).Seconds(), string(models.RevisionFailed))
+		return nil, &ApplyError{Err: err, RolledBack: rolledBack}
+	}
+
+	deploy.commit(ctx)
+	recordRevision(name, revision, yamlData, config, options, nil)
+	applyDuration.observe(time.Since(start).Seconds(), string(models.RevisionApplied))
+
+	if len(deploy.waiting) > 0 {
+		starting = true
+		waiting := deploy.waiting
+		goStackAction(name, "start", func() error { return startWaiting(waiting) })
+	}
+	return result, nil
+}
+
+// startWaiting starts the containers an apply left waiting, each once its
+// dependencies have reached their condition
+func startWaiting(waiting []waitingContainer) error {
+	ctx := context.Background()
+	for _, w := range waiting {
+		if err := waitForDependencies(ctx, w.config); err != nil {
+			return err
+		}
+		if err := startContainer(ctx, w.config.Name, w.containerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deployStack performs the changes of applyStack, saving to the database
+// through tx and recording every Docker change in deploy
+func deployStack(ctx context.Context, tx *gorm.DB, deploy *deployment, name string, revision int, yamlData []byte, config ContainersConfig, ordered []ContainerConfig, prune bool) (*ApplyResult, error) {
+	// Find or create the stack record
+	var stack models.Stack
+	err := tx.Where("name = ?", name).First(&stack).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	stack.Name = name
+	stack.SourceYAML = string(yamlData)
+	stack.Revision = revision
+	if err := tx.Save(&stack).Error; err != nil {
+		return nil, fmt.Errorf("failed to save stack '%s': %w", name, err)
+	}
+
+	result := &ApplyResult{
+		Stack:     stack.Name,
+		Revision:  stack.Revision,
+		Created:   []string{},
+		Recreated: []string{},
+		Unchanged: []string{},
+		Pruned:    []string{},
+		Starting:  []string{},
+	}
+
+	// Networks must exist before containers can be attached to them
+	createdNetworks, err := ensureNetworks(stack.Name, config)
+	deploy.networks = append(deploy.networks, createdNetworks...)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := make(map[string]bool)
+	starting := make(map[string]string)
+	for _, containerConfig := range ordered {
+		desired[containerConfig.Name] = true
+		hash := specHash(containerConfig)
+
+		info, inspectErr := dockerClient.ContainerInspect(ctx, containerConfig.Name)
+		if inspectErr != nil && !errdefs.IsNotFound(inspectErr) {
+			return nil, fmt.Errorf("failed to inspect container '%s': %w", containerConfig.Name, inspectErr)
+		}
+		exists := inspectErr == nil
+
+		var containerID string
+		var status models.ContainerStatus
+		if exists && info.Config.Labels[labelSpecHash] == hash {
+			// Identical spec, leave the container as it is
+			containerID = info.ID
+			status = models.ContainerStatus(info.State.Status)
+			result.Unchanged = append(result.Unchanged, containerConfig.Name)
+		} else {
+			// Keep the previous container until the whole stack is deployed
+			if exists {
+				if err := deploy.backup(ctx, info); err != nil {
+					return nil, err
+				}
+			}
+
+			containerID, err = createDockerContainer(containerConfig, stack.Name)
+			if containerID != "" {
+				deploy.created = append(deploy.created, containerConfig.Name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to create container '%s': %w", containerConfig.Name, err)
+			}
+			status = models.StatusCreated
+
+			// New containers are started, replaced ones are brought back to
+			// the state they were in
+			if !exists || info.State.Running {
+				starting[containerConfig.Name] = containerID
+			}
+
+			if exists {
+				result.Recreated = append(result.Recreated, containerConfig.Name)
+			} else {
+				result.Created = append(result.Created, containerConfig.Name)
+			}
+		}
+
+		// Reuse the existing row when the container was deployed with the
+		// stack before, or adopt it when it belongs to no stack
+		var containerObj models.Container
+		err = tx.Where("name = ? AND (stack_id = ? OR stack_id IS NULL)", containerConfig.Name, stack.ID).First(&containerObj).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		containerObj.Name = containerConfig.Name
+		containerObj.Image = containerConfig.Image
+		containerObj.Ports = containerConfig.Ports
+		containerObj.ContainerID = containerID
+		containerObj.SpecHash = hash
+		containerObj.Status = status
+		containerObj.StackID = &stack.ID
+		if err := tx.Save(&containerObj).Error; err != nil {
+			return nil, fmt.Errorf("failed to save container '%s' to database: %w", containerConfig.Name, err)
+		}
+	}
+
+	// Start containers once the whole stack exists, in dependency order.
+	// Waiting for a dependency, such as a healthcheck to pass, can outlast
+	// the request, so the first container whose dependencies aren't ready
+	// and every one after it are started in the background once the apply
+	// is committed.
+	for _, containerConfig := range ordered {
+		containerID, ok := starting[containerConfig.Name]
+		if !ok {
+			continue
+		}
+		if len(deploy.waiting) == 0 {
+			ready, err := dependenciesReady(ctx, containerConfig)
+			if err != nil {
+				return nil, err
+			}
+			if ready {
+				if err := dockerClient.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+					return nil, fmt.Errorf("failed to start container '%s': %w", containerConfig.Name, err)
+				}
+				err := tx.Model(&models.Container{}).Where("container_id = ?", containerID).Update("status", models.StatusRunning).Error
+				if err != nil {
+					return nil, fmt.Errorf("failed to save container '%s' to database: %w", containerConfig.Name, err)
+				}
+				continue
+			}
+		}
+		deploy.waiting = append(deploy.waiting, waitingContainer{config: containerConfig, containerID: containerID})
+		result.Starting = append(result.Starting, containerConfig.Name)
+	}
+
+	if prune {
+		var existing []models.Container
+		if err := tx.Where("stack_id = ?", stack.ID).Find(&existing).Error; err != nil {
+			return nil, err
+		}
+
+		for _, containerObj := range existing {
+			if desired[containerObj.Name] {
+				continue
+			}
+
+			// Pruned containers are backed up too and only removed on commit
+			info, err := dockerClient.ContainerInspect(ctx, containerObj.Name)
+			if err != nil && !errdefs.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to inspect container '%s': %w", containerObj.Name, err)
+			}
+			if err == nil {
+				if err := deploy.backup(ctx, info); err != nil {
+					return nil, fmt.Errorf("failed to prune container '%s': %w", containerObj.Name, err)
+				}
+			}
+			if err := tx.Delete(&containerObj).Error; err != nil {
+				return nil, err
+			}
+			result.Pruned = append(result.Pruned, containerObj.Name)
+		}
+	}
+
+	return result, nil
+}
+
+// checkNameConflicts returns a *ConflictError when containers of a stack
+// are named like containers that belong to another stack, either in the
+// database or by their Docker label
+func checkNameConflicts(ctx context.Context, stackName string, containers []ContainerConfig) error {
+	names := make([]string, len(containers))
+	for i, containerConfig := range containers {
+		names[i] = containerConfig.Name
+	}
+
+	var conflicts []NameConflict
+	err := database.GetDB().Table("containers").
+		Select("containers.name AS container, stacks.name AS stack").
+		Joins("JOIN stacks ON stacks.id = containers.stack_id").
+		Where("containers.name IN ? AND stacks.name <> ?", names, stackName).
+		Order("containers.name").
+		Scan(&conflicts).Error
+	if err != nil {
+		return fmt.Errorf("failed to check container names: %w", err)
+	}
+
+	// Containers whose row is gone still carry their stack's label
+	found := make(map[string]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		found[conflict.Container] = true
+	}
+	for _, name := range names {
+		if found[name] {
+			continue
+		}
+		info, err := dockerClient.ContainerInspect(ctx, name)
+		if errdefs.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to inspect container '%s': %w", name, err)
+		}
+		if owner := info.Config.Labels[labelStack]; owner != "" && owner != stackName {
+			conflicts = append(conflicts, NameConflict{Container: name, Stack: owner})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
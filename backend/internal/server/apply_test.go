@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"github.com/docker/docker/api/types/network"
+	"github.com/hspgit/DockFormer/internal/models"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// dependentStack has an app waiting for its database to be healthy
+const dependentStack = `name: shop
+containers:
+  - name: app
+    image: shop/app:1.0
+    ports: "8080:80"
+    depends_on:
+      db:
+        condition: healthy
+  - name: db
+    image: postgres:16
+    ports: ""
+    healthcheck:
+      test: pg_isready
+`
+
+func TestApplyStartsContainersInDependencyOrder(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+
+	// app waits for db to turn healthy, after the response
+	response := s.do(admin, http.MethodPost, "/api/apply", dependentStack)
+	if response.Code != http.StatusOK {
+		t.Fatalf("apply returned %d: %s", response.Code, response.Body.String())
+	}
+	var result ApplyResult
+	decode(t, response, &result)
+	if !reflect.DeepEqual(result.Starting, []string{"app"}) {
+		t.Errorf("expected app to be started in the background, got %v", result.Starting)
+	}
+	if !s.running("db") || s.running("app") {
+		t.Fatal("expected only db to run when the apply returns")
+	}
+
+	// The stack can't be applied again while app is waiting
+	if response := s.do(admin, http.MethodPost, "/api/apply", dependentStack); response.Code != http.StatusConflict {
+		t.Errorf("expected 409 for an apply during the start, got %d: %s", response.Code, response.Body.String())
+	}
+
+	waitForStack(t, "shop")
+	ctx := context.Background()
+	app, err := s.engine.ContainerInspect(ctx, "app")
+	if err != nil {
+		t.Fatalf("failed to inspect app: %v", err)
+	}
+	db, err := s.engine.ContainerInspect(ctx, "db")
+	if err != nil {
+		t.Fatalf("failed to inspect db: %v", err)
+	}
+	if !app.State.Running || !db.State.Running {
+		t.Fatalf("expected both containers to run, app is %s and db is %s", app.State.Status, db.State.Status)
+	}
+
+	// app may only start once db has turned healthy
+	appStarted, _ := time.Parse(time.RFC3339Nano, app.State.StartedAt)
+	dbStarted, _ := time.Parse(time.RFC3339Nano, db.State.StartedAt)
+	if appStarted.Sub(dbStarted) < fakeHealthDelay {
+		t.Errorf("app started %s after db, before db could be healthy", appStarted.Sub(dbStarted))
+	}
+
+	for _, name := range []string{"app", "db"} {
+		if status := s.container(name).Status; status != models.StatusRunning {
+			t.Errorf("expected %s to be recorded as running, got %s", name, status)
+		}
+	}
+}
+
+func TestApplyRejectsContainersOfOtherStacks(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, "name: alpha\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+	original := s.container("web")
+
+	takeover := "name: beta\ncontainers:\n  - name: web\n    image: nginx:1.28\n    ports: \"8080:80\"\n"
+	for _, target := range []string{"/api/plan", "/api/apply"} {
+		t.Run(target, func(t *testing.T) {
+			response := s.do(admin, http.MethodPost, target, takeover)
+			if response.Code != http.StatusConflict {
+				t.Fatalf("expected 409, got %d: %s", response.Code, response.Body.String())
+			}
+
+			var body struct {
+				Conflicts []NameConflict `json:"conflicts"`
+			}
+			decode(t, response, &body)
+			expected := []NameConflict{{Container: "web", Stack: "alpha"}}
+			if !reflect.DeepEqual(body.Conflicts, expected) {
+				t.Errorf("expected conflicts %v, got %v", expected, body.Conflicts)
+			}
+		})
+	}
+
+	if current := s.container("web"); current.ID != original.ID || *current.StackID != *original.StackID || current.Image != "nginx:1.27" {
+		t.Errorf("container of alpha was taken over: %+v", current)
+	}
+	info, err := s.engine.ContainerInspect(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("failed to inspect web: %v", err)
+	}
+	if info.Config.Labels[labelStack] != "alpha" || info.Config.Image != "nginx:1.27" {
+		t.Errorf("Docker container of alpha was replaced: %s in %s", info.Config.Image, info.Config.Labels[labelStack])
+	}
+}
+
+func TestPlan(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	viewer := s.user("viewer", models.RoleViewer)
+	s.apply(admin, `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80"
+  - name: cache
+    image: redis:7
+    ports: ""
+  - name: worker
+    image: shop/worker:1.0
+    ports: ""
+`)
+
+	// web changes, cache stays, worker is dropped and api is new
+	response := s.do(viewer, http.MethodPost, "/api/plan", `name: site
+containers:
+  - name: web
+    image: nginx:1.28
+    ports: "8080:80"
+  - name: cache
+    image: redis:7
+    ports: ""
+  - name: api
+    image: shop/api:1.0
+    ports: "9090:80"
+`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("plan returned %d: %s", response.Code, response.Body.String())
+	}
+
+	var plan Plan
+	decode(t, response, &plan)
+	expected := Plan{
+		Stack:  "site",
+		Create: []PlanEntry{{Name: "api", Image: "shop/api:1.0"}},
+		Recreate: []PlanEntry{{
+			Name:    "web",
+			Image:   "nginx:1.28",
+			Changes: []FieldChange{{Field: "image", Current: "nginx:1.27", Desired: "nginx:1.28"}},
+		}},
+		Unchanged: []PlanEntry{{Name: "cache", Image: "redis:7"}},
+		Orphaned:  []PlanEntry{{Name: "worker", Image: "shop/worker:1.0"}},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("expected plan\n%+v\ngot\n%+v", expected, plan)
+	}
+
+	// Planning changes nothing
+	if image := s.container("web").Image; image != "nginx:1.27" {
+		t.Errorf("expected web to keep nginx:1.27, got %s", image)
+	}
+	if _, err := s.engine.ContainerInspect(context.Background(), "api"); err == nil {
+		t.Error("expected the plan not to create api")
+	}
+
+	t.Run("invalid file", func(t *testing.T) {
+		response := s.do(viewer, http.MethodPost, "/api/plan", "name: site\ncontainers:\n  - name: web\n")
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d: %s", response.Code, response.Body.String())
+		}
+	})
+}
+
+func TestApplyExternalNetworks(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	stack := "name: site\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n    networks: [shared]\nnetworks:\n  shared:\n    external: true\n"
+
+	// A missing external network is never created
+	response := s.do(admin, http.MethodPost, "/api/apply", stack)
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("expected the apply to fail, got %d: %s", response.Code, response.Body.String())
+	}
+	ctx := context.Background()
+	if _, err := s.engine.NetworkInspect(ctx, "shared", network.InspectOptions{}); err == nil {
+		t.Fatal("expected the external network not to be created")
+	}
+
+	if _, err := s.engine.NetworkCreate(ctx, "shared", network.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create network: %v", err)
+	}
+	s.apply(admin, stack)
+
+	// Deleting the stack leaves the network to whoever manages it
+	if response := s.do(admin, http.MethodDelete, "/api/stacks/site", nil); response.Code != http.StatusOK {
+		t.Fatalf("delete returned %d: %s", response.Code, response.Body.String())
+	}
+	info, err := s.engine.NetworkInspect(ctx, "shared", network.InspectOptions{})
+	if err != nil {
+		t.Fatalf("expected the external network to be kept: %v", err)
+	}
+	if owner := info.Labels[labelStack]; owner != "" {
+		t.Errorf("expected the external network not to be labelled, got %s", owner)
+	}
+}
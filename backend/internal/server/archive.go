@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the log archive, overridden by DOCKFORMER_LOG_RETENTION and
+// DOCKFORMER_LOG_MAX_ENTRIES
+const (
+	defaultLogRetention  = 7 * 24 * time.Hour
+	defaultLogMaxEntries = 1000000
+)
+
+// Timing of the log collector
+const (
+	// archiveScanInterval is how often containers without a tail are checked
+	archiveScanInterval = 10 * time.Second
+	// archiveFlushInterval bounds how long lines are buffered before saving
+	archiveFlushInterval = time.Second
+	// archiveBatchSize is the number of lines saved at once
+	archiveBatchSize = 500
+	// archivePruneInterval is how often retention limits are enforced
+	archivePruneInterval = 10 * time.Minute
+)
+
+// Bounds of a log search
+const (
+	// defaultSearchLimit and maxSearchLimit bound the number of results
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	// regexScanBatch is the number of entries fetched at once to be matched
+	// against a regular expression
+	regexScanBatch = 1000
+	// maxRegexScan is the number of entries a regular expression search
+	// looks at before giving up
+	maxRegexScan = 100000
+)
+
+// logArchive tails the output of every managed container into the
+// log_entries table
+type logArchive struct {
+	retention  time.Duration
+	maxEntries int64
+
+	mu    sync.Mutex
+	tails map[string]bool
+}
+
+// API handlers
+func searchLogs(c *gin.Context) {
+	query := database.GetDB().Model(&models.LogEntry{})
+
+	// Regular expressions are matched in Go rather than by the database,
+	// whose dialect differs and which may take exponential time
+	var pattern *regexp.Regexp
+	if text := c.Query("q"); text != "" {
+		if c.Query("regex") == "true" {
+			var err error
+			if pattern, err = regexp.Compile(text); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid regex: %v", err)})
+				return
+			}
+		} else {
+			query = query.Where("text ILIKE ?", "%"+likeEscape(text)+"%")
+		}
+	}
+	if name := c.Query("container"); name != "" {
+		query = query.Where("container_name = ?", name)
+	}
+	if stream := c.Query("stream"); stream != "" {
+		if stream != StreamStdout && stream != StreamStderr {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "stream must be 'stdout' or 'stderr'"})
+			return
+		}
+		query = query.Where("stream = ?", stream)
+	}
+
+	for _, bound := range []struct {
+		param     string
+		condition string
+	}{
+		{"since", "timestamp >= ?"},
+		{"until", "timestamp < ?"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an RFC 3339 time", bound.param)})
+			return
+		}
+		query = query.Where(bound.condition, t)
+	}
+
+	limit := defaultSearchLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	if pattern != nil {
+		entries, truncated, err := scanLogEntries(query, pattern, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if truncated {
+			c.Header("X-Search-Truncated", "true")
+		}
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+
+	var entries []models.LogEntry
+	if err := query.Order("timestamp desc, id desc").Limit(limit).Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// Helper functions
+
+// scanLogEntries returns the newest entries matching query whose text
+// matches pattern, at most limit of them. Entries are fetched in batches,
+// newest first, and the scan stops after maxRegexScan entries, reporting
+// whether older entries were left unsearched.
+func scanLogEntries(query *gorm.DB, pattern *regexp.Regexp, limit int) ([]models.LogEntry, bool, error) {
+	base := query.Session(&gorm.Session{})
+	matches := []models.LogEntry{}
+
+	var last *models.LogEntry
+	for scanned := 0; scanned < maxRegexScan; {
+		batchQuery := base
+		if last != nil {
+			batchQuery = batchQuery.Where("(timestamp < ? OR (timestamp = ? AND id < ?))", last.Timestamp, last.Timestamp, last.ID)
+		}
+
+		size := min(regexScanBatch, maxRegexScan-scanned)
+		var batch []models.LogEntry
+		if err := batchQuery.Order("timestamp desc, id desc").Limit(size).Find(&batch).Error; err != nil {
+			return nil, false, err
+		}
+
+		for _, entry := range batch {
+			if pattern.MatchString(entry.Text) {
+				matches = append(matches, entry)
+				if len(matches) == limit {
+					return matches, false, nil
+				}
+			}
+		}
+		if len(batch) < size {
+			return matches, false, nil
+		}
+		scanned += len(batch)
+		last = &batch[len(batch)-1]
+	}
+	return matches, true, nil
+}
+
+// newLogArchive reads the archive's retention limits from the environment.
+// A retention of 0 disables the archive and nil is returned.
+func newLogArchive() *logArchive {
+	archive := &logArchive{
+		retention:  defaultLogRetention,
+		maxEntries: defaultLogMaxEntries,
+		tails:      make(map[string]bool),
+	}
+
+	if value := os.Getenv("DOCKFORMER_LOG_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err != nil || retention < 0 {
+			log.Printf("Invalid DOCKFORMER_LOG_RETENTION '%s', using %s", value, defaultLogRetention)
+		} else {
+			archive.retention = retention
+		}
+	}
+	if archive.retention == 0 {
+		return nil
+	}
+
+	if value := os.Getenv("DOCKFORMER_LOG_MAX_ENTRIES"); value != "" {
+		maxEntries, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxEntries <= 0 {
+			log.Printf("Invalid DOCKFORMER_LOG_MAX_ENTRIES '%s', using %d", value, defaultLogMaxEntries)
+		} else {
+			archive.maxEntries = maxEntries
+		}
+	}
+
+	return archive
+}
+
+// run tails every managed container until ctx is cancelled. Containers are
+// checked periodically so new and restarted containers get picked up.
+func (a *logArchive) run(ctx context.Context) {
+	scan := time.NewTicker(archiveScanInterval)
+	defer scan.Stop()
+	prune := time.NewTicker(archivePruneInterval)
+	defer prune.Stop()
+
+	a.prune()
+	for {
+		a.tailContainers(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-prune.C:
+			a.prune()
+		case <-scan.C:
+		}
+	}
+}
+
+// tailContainers starts a tail for every running container without one
+func (a *logArchive) tailContainers(ctx context.Context) {
+	var containerList []models.Container
+	err := database.GetDB().Where("status = ?", models.StatusRunning).Find(&containerList).Error
+	if err != nil {
+		log.Printf("Log archive failed to load containers: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, containerObj := range containerList {
+		if a.tails[containerObj.Name] {
+			continue
+		}
+		a.tails[containerObj.Name] = true
+		go a.tail(ctx, containerObj.Name)
+	}
+}
+
+// tail archives a container's output until it stops. Lines are resumed from
+// the last archived timestamp so restarts of the collector don't duplicate
+// them.
+func (a *logArchive) tail(ctx context.Context, name string) {
+	defer func() {
+		a.mu.Lock()
+		delete(a.tails, name)
+		a.mu.Unlock()
+	}()
+
+	var last models.LogEntry
+	result := database.GetDB().Where("container_name = ?", name).Order("timestamp desc").Limit(1).Find(&last)
+	if result.Error != nil {
+		log.Printf("Log archive failed to resume %s: %v", name, result.Error)
+		return
+	}
+
+	options := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Timestamps: true,
+	}
+	if result.RowsAffected > 0 {
+		options.Since = fmt.Sprintf("%d.%09d", last.Timestamp.Unix(), last.Timestamp.Nanosecond())
+	} else {
+		options.Since = strconv.FormatInt(time.Now().Add(-a.retention).Unix(), 10)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	logReader, tty, err := openContainerLogs(ctx, name, options)
+	if err != nil {
+		log.Printf("Log archive failed to tail %s: %v", name, err)
+		return
+	}
+	defer closeLogReader(logReader)
+
+	var batch []models.LogEntry
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := database.GetDB().CreateInBatches(batch, archiveBatchSize).Error; err != nil {
+			log.Printf("Log archive failed to save %d line(s) of %s: %v", len(batch), name, err)
+		}
+		batch = batch[:0]
+	}
+	defer flush()
+
+	dockerID := ""
+	if info, err := dockerClient.ContainerInspect(ctx, name); err == nil {
+		dockerID = info.ID
+	}
+
+	ticker := time.NewTicker(archiveFlushInterval)
+	defer ticker.Stop()
+
+	records := logRecords(ctx, logReader, tty, options.Timestamps)
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				return
+			}
+			// Since is inclusive, skip what was archived already
+			if result.RowsAffected > 0 && !record.Timestamp.After(last.Timestamp) {
+				continue
+			}
+			batch = append(batch, models.LogEntry{
+				ContainerName: name,
+				ContainerID:   dockerID,
+				Stream:        record.Stream,
+				Timestamp:     record.Timestamp,
+				Text:          record.Text,
+			})
+			if len(batch) >= archiveBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// prune enforces the retention period and the maximum number of entries
+func (a *logArchive) prune() {
+	db := database.GetDB()
+
+	result := db.Where("timestamp < ?", time.Now().Add(-a.retention)).Delete(&models.LogEntry{})
+	if result.Error != nil {
+		log.Printf("Log archive failed to prune old entries: %v", result.Error)
+		return
+	}
+	pruned := result.RowsAffected
+
+	// Drop the oldest entries beyond the limit
+	result = db.Exec(
+		"DELETE FROM log_entries WHERE id IN (SELECT id FROM log_entries ORDER BY timestamp DESC, id DESC OFFSET ?)",
+		a.maxEntries,
+	)
+	if result.Error != nil {
+		log.Printf("Log archive failed to enforce entry limit: %v", result.Error)
+		return
+	}
+	pruned += result.RowsAffected
+
+	if pruned > 0 {
+		log.Printf("Log archive pruned %d entries", pruned)
+	}
+}
+
+// likeEscape escapes the wildcards of a LIKE pattern
+func likeEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
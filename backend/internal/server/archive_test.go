@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSearchLogsRegex(t *testing.T) {
+	s := newTestServer(t)
+	viewer := s.user("viewer", models.RoleViewer)
+
+	// Three entries share each second, so batches split ties on the ID
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := make([]models.LogEntry, 2500)
+	for i := range entries {
+		text := fmt.Sprintf("request %d served", i)
+		if i%7 == 0 {
+			text = fmt.Sprintf("ERROR request %d failed", i)
+		}
+		entries[i] = models.LogEntry{
+			ContainerName: "web",
+			ContainerID:   "abc",
+			Stream:        StreamStdout,
+			Timestamp:     base.Add(time.Duration(i/3) * time.Second),
+			Text:          text,
+		}
+	}
+	if err := database.GetDB().CreateInBatches(entries, 500).Error; err != nil {
+		t.Fatalf("failed to archive entries: %v", err)
+	}
+
+	var expected []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		if i%7 == 0 {
+			expected = append(expected, entries[i].Text)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"every match across batches", "q=(?i)^error&regex=true&limit=1000", expected},
+		{"limit", "q=failed$&regex=true&limit=5", expected[:5]},
+		{"no match", "q=^panic&regex=true", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := s.do(viewer, http.MethodGet, "/api/logs/search?"+test.query, nil)
+			if response.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", response.Code, response.Body.String())
+			}
+			if response.Header().Get("X-Search-Truncated") != "" {
+				t.Error("expected the search not to be truncated")
+			}
+
+			var found []models.LogEntry
+			decode(t, response, &found)
+			if len(found) != len(test.expected) {
+				t.Fatalf("expected %d entries, got %d", len(test.expected), len(found))
+			}
+			for i, entry := range found {
+				if entry.Text != test.expected[i] {
+					t.Fatalf("entry %d: expected %q, got %q", i, test.expected[i], entry.Text)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid regex", func(t *testing.T) {
+		// Backreferences are valid in PostgreSQL but not in Go
+		response := s.do(viewer, http.MethodGet, `/api/logs/search?regex=true&q=(a)\1`, nil)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d: %s", response.Code, response.Body.String())
+		}
+	})
+}
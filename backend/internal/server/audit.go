@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gorm.io/gorm"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Bounds of the audit log API
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+	// maxAuditExport is the most events a single export returns
+	maxAuditExport = 100000
+	// maxAuditErrorBody is how much of an error response is kept to find
+	// its message
+	maxAuditErrorBody = 4096
+)
+
+// Keys of the audit state in the Gin context
+const (
+	auditEventKey  = "auditEvent"
+	auditDeniedKey = "auditDenied"
+	payloadBodyKey = "payloadBody"
+)
+
+// auditTarget returns the container and stack a request acts on
+type auditTarget func(c *gin.Context) (containerName string, stackName string)
+
+// hashingBody hashes a request body as it is read
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+// auditWriter keeps the start of error responses so their message can be
+// recorded
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// API handlers
+func getAuditEvents(c *gin.Context) {
+	query, ok := auditQuery(c)
+	if !ok {
+		return
+	}
+
+	page, perPage := 1, defaultAuditPageSize
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{"page", &page},
+		{"per_page", &perPage},
+	} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a positive number", param.name)})
+			return
+		}
+		*param.value = n
+	}
+	perPage = min(perPage, maxAuditPageSize)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var events []models.AuditEvent
+	err := query.Order("created_at desc, id desc").Offset((page - 1) * perPage).Limit(perPage).Find(&events).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":   events,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+func exportAuditEvents(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 'json' or 'csv'"})
+		return
+	}
+
+	query, ok := auditQuery(c)
+	if !ok {
+		return
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("created_at desc, id desc").Limit(maxAuditExport).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("dockformer-audit-%s.%s", time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "json" {
+		c.JSON(http.StatusOK, events)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{
+		"id", "created_at", "username", "client_ip", "action", "method", "path",
+		"container", "stack", "payload_digest", "status", "outcome", "error",
+	})
+	for _, event := range events {
+		w.Write([]string{
+			strconv.FormatUint(uint64(event.ID), 10),
+			event.CreatedAt.Format(time.RFC3339),
+			event.Username,
+			event.ClientIP,
+			event.Action,
+			event.Method,
+			event.Path,
+			event.Container,
+			event.Stack,
+			event.PayloadDigest,
+			strconv.Itoa(event.Status),
+			string(event.Outcome),
+			event.Error,
+		})
+	}
+	w.Flush()
+}
+
+// Helper functions
+
+// hashPayload hashes the body of state-changing requests as handlers read
+// it, for the payload digest of audit events
+func hashPayload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			body := &hashingBody{ReadCloser: c.Request.Body, hash: sha256.New()}
+			c.Request.Body = body
+			c.Set(payloadBodyKey, body)
+		}
+		c.Next()
+	}
+}
+
+// Read hashes what is read from the body
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+// audit records the request as an audit event once it has been handled.
+// It goes before the role check so denied attempts are recorded with their
+// action too.
+func audit(action string, target auditTarget) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		event := newAuditEvent(c, action)
+		if target != nil {
+			event.Container, event.Stack = target(c)
+		}
+		c.Set(auditEventKey, &event)
+
+		writer := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		event.PayloadDigest = payloadDigest(c)
+		event.Status = writer.Status()
+
+		switch reason, denied := c.Get(auditDeniedKey); {
+		case denied:
+			event.Outcome = models.AuditDenied
+			event.Error = reason.(string)
+		case event.Status >= http.StatusBadRequest:
+			event.Outcome = models.AuditFailed
+			event.Error = writer.errorMessage()
+		default:
+			event.Outcome = models.AuditSucceeded
+		}
+		recordAuditEvent(event)
+	}
+}
+
+// recordAuditEvent saves an audit event, logging rather than failing the
+// request if it can't be saved
+func recordAuditEvent(event models.AuditEvent) {
+	if err := database.GetDB().Create(&event).Error; err != nil {
+		log.Printf("Failed to record audit event %s: %v", event, err)
+	}
+}
+
+// newAuditEvent starts an audit event for the request
+func newAuditEvent(c *gin.Context, action string) models.AuditEvent {
+	event := models.AuditEvent{
+		ClientIP: c.ClientIP(),
+		Action:   action,
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+	}
+	if user := currentUser(c); user != nil {
+		event.Username = user.Username
+	}
+	return event
+}
+
+// setAuditTarget records the target of an audited request that is only
+// known once its payload is parsed, such as the stack of an upload
+func setAuditTarget(c *gin.Context, containerName string, stackName string) {
+	if value, ok := c.Get(auditEventKey); ok {
+		event := value.(*models.AuditEvent)
+		event.Container, event.Stack = containerName, stackName
+	}
+}
+
+// payloadDigest returns the SHA-256 of the request body, reading whatever
+// the handler left unread. Requests without a body have no digest.
+func payloadDigest(c *gin.Context) string {
+	value, ok := c.Get(payloadBodyKey)
+	if !ok {
+		return ""
+	}
+	body := value.(*hashingBody)
+	io.Copy(io.Discard, body)
+	return hex.EncodeToString(body.hash.Sum(nil))
+}
+
+// containerTarget resolves the container in the :id parameter and its stack
+func containerTarget(c *gin.Context) (string, string) {
+	var containerObj models.Container
+	if err := database.GetDB().First(&containerObj, c.Param("id")).Error; err != nil {
+		return "", ""
+	}
+	if containerObj.StackID == nil {
+		return containerObj.Name, ""
+	}
+
+	var stack models.Stack
+	if err := database.GetDB().First(&stack, *containerObj.StackID).Error; err != nil {
+		return containerObj.Name, ""
+	}
+	return containerObj.Name, stack.Name
+}
+
+// stackTarget resolves the stack named in the :name parameter
+func stackTarget(c *gin.Context) (string, string) {
+	return "", c.Param("name")
+}
+
+// grantTarget resolves the stack named in the :stack parameter of a grant
+func grantTarget(c *gin.Context) (string, string) {
+	return "", c.Param("stack")
+}
+
+// auditQuery builds the query for the audit event filters of a request,
+// writing a 400 if one is invalid
+func auditQuery(c *gin.Context) (*gorm.DB, bool) {
+	query := database.GetDB().Model(&models.AuditEvent{})
+
+	for _, filter := range []struct {
+		param  string
+		column string
+	}{
+		{"user", "username"},
+		{"action", "action"},
+		{"outcome", "outcome"},
+		{"container", "container"},
+		{"stack", "stack"},
+	} {
+		if value := c.Query(filter.param); value != "" {
+			query = query.Where(filter.column+" = ?", value)
+		}
+	}
+
+	for _, bound := range []struct {
+		param     string
+		condition string
+	}{
+		{"since", "created_at >= ?"},
+		{"until", "created_at < ?"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an RFC 3339 time", bound.param)})
+			return nil, false
+		}
+		query = query.Where(bound.condition, t)
+	}
+
+	return query, true
+}
+
+// Write keeps the start of error responses
+func (w *auditWriter) Write(data []byte) (int, error) {
+	w.keep(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString keeps the start of error responses
+func (w *auditWriter) WriteString(s string) (int, error) {
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// keep buffers data if it is part of an error response
+func (w *auditWriter) keep(data []byte) {
+	if w.Status() < http.StatusBadRequest {
+		return
+	}
+	if room := maxAuditErrorBody - w.body.Len(); room > 0 {
+		w.body.Write(data[:min(len(data), room)])
+	}
+}
+
+// errorMessage returns the message of an error response, the "error" field
+// of API responses or the status text otherwise
+func (w *auditWriter) errorMessage() string {
+	var response struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(w.body.Bytes(), &response) == nil && response.Error != "" {
+		return response.Error
+	}
+	return http.StatusText(w.Status())
+}
@@ -0,0 +1,583 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Authentication settings
+const (
+	// sessionCookie holds the session token of a signed-in browser
+	sessionCookie = "dockformer_session"
+	// defaultSessionTTL is how long a session lasts, overridden by
+	// DOCKFORMER_SESSION_TTL
+	defaultSessionTTL = 24 * time.Hour
+	// defaultAdminUser is the name of the bootstrap admin unless
+	// DOCKFORMER_ADMIN_USER is set
+	defaultAdminUser = "admin"
+	// minPasswordLength is the shortest password accepted for new users
+	minPasswordLength = 8
+	// tokenPrefix marks DockFormer API tokens so they are easy to recognize
+	tokenPrefix = "dft_"
+)
+
+// Keys of the authenticated user and session in the Gin context
+const (
+	userContextKey    = "user"
+	sessionContextKey = "session"
+)
+
+// dummyPasswordHash is compared against when a login names an unknown user,
+// so failed logins take as long whether or not the user exists
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dockformer"), bcrypt.DefaultCost)
+
+// UserRequest is the body of a request creating a user. Role defaults to
+// viewer.
+type UserRequest struct {
+	Username string      `json:"username" binding:"required"`
+	Password string      `json:"password" binding:"required"`
+	Role     models.Role `json:"role"`
+}
+
+// UserResponse is a user as returned by the API
+type UserResponse struct {
+	ID        uint        `json:"id"`
+	Username  string      `json:"username"`
+	Role      models.Role `json:"role"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// TokenRequest is the body of a request creating an API token. ExpiresIn is
+// a duration such as "720h"; tokens without one don't expire.
+type TokenRequest struct {
+	Name      string `json:"name" binding:"required"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// TokenResponse is an API token as returned by the API. Token is only set
+// when the token is created.
+type TokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Web UI handlers
+func loginPageHandler(c *gin.Context) {
+	// Skip the form for browsers that are already signed in
+	if session, err := findSession(c); err == nil && session != nil {
+		c.Redirect(http.StatusSeeOther, safeRedirect(c.Query("next")))
+		return
+	}
+
+	c.HTML(http.StatusOK, "login.html", gin.H{
+		"next": c.Query("next"),
+	})
+}
+
+func loginHandler(c *gin.Context) {
+	username := strings.TrimSpace(c.PostForm("username"))
+	password := c.PostForm("password")
+	next := c.PostForm("next")
+
+	user, err := checkPassword(username, password)
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if user == nil {
+		log.Printf("Failed login for '%s' from %s", username, c.ClientIP())
+		c.HTML(http.StatusUnauthorized, "login.html", gin.H{
+			"error":    "Invalid username or password",
+			"username": username,
+			"next":     next,
+		})
+		return
+	}
+
+	if err := startSession(c, user); err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
+			"error": "Failed to start session: " + err.Error(),
+		})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, safeRedirect(next))
+}
+
+func logoutHandler(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookie); err == nil {
+		err := database.GetDB().Where("token_hash = ?", hashToken(token)).Delete(&models.Session{}).Error
+		if err != nil {
+			log.Printf("Failed to delete session: %v", err)
+		}
+	}
+	setSessionCookie(c, "", -1)
+
+	c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// API handlers
+func getUsers(c *gin.Context) {
+	var users []models.User
+	if err := database.GetDB().Order("username").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := make([]UserResponse, len(users))
+	for i, user := range users {
+		response[i] = userResponse(user)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func createUser(c *gin.Context) {
+	var request UserRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Role == "" {
+		request.Role = models.RoleViewer
+	}
+	user, err := newUser(request.Username, request.Password, request.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	database.GetDB().Model(&models.User{}).Where("username = ?", user.Username).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("User '%s' already exists", user.Username)})
+		return
+	}
+
+	if err := database.GetDB().Create(user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, userResponse(*user))
+}
+
+func deleteUser(c *gin.Context) {
+	id := c.Param("id")
+	var user models.User
+
+	if err := database.GetDB().First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.ID == currentUser(c).ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account"})
+		return
+	}
+
+	// Sessions and tokens of the user are removed with it
+	if err := database.GetDB().Delete(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+func getAPITokens(c *gin.Context) {
+	var tokens []models.APIToken
+	err := database.GetDB().Where("user_id = ?", currentUser(c).ID).Order("created_at desc").Find(&tokens).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := make([]TokenResponse, len(tokens))
+	for i, token := range tokens {
+		response[i] = tokenResponse(token)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func createAPIToken(c *gin.Context) {
+	var request TokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	apiToken := models.APIToken{
+		Name:   request.Name,
+		UserID: currentUser(c).ID,
+	}
+	if request.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(request.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be a positive duration such as '720h'"})
+			return
+		}
+		expiresAt := time.Now().Add(ttl)
+		apiToken.ExpiresAt = &expiresAt
+	}
+
+	token, err := newToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	token = tokenPrefix + token
+	apiToken.TokenHash = hashToken(token)
+
+	if err := database.GetDB().Create(&apiToken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := tokenResponse(apiToken)
+	response.Token = token
+	c.JSON(http.StatusCreated, response)
+}
+
+func deleteAPIToken(c *gin.Context) {
+	id := c.Param("id")
+	var apiToken models.APIToken
+
+	err := database.GetDB().Where("user_id = ?", currentUser(c).ID).First(&apiToken, id).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	if err := database.GetDB().Delete(&apiToken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
+
+// Helper functions
+
+// requireAuth rejects requests without a valid session cookie or bearer
+// token. API requests get a 401, browsers are sent to the login page.
+func requireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, session, err := authenticate(c)
+		if err != nil {
+			log.Printf("Failed to authenticate request: %v", err)
+		}
+		if user == nil {
+			if isAPIRequest(c) {
+				c.Header("WWW-Authenticate", `Bearer realm="DockFormer"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+				return
+			}
+			c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+			c.Abort()
+			return
+		}
+
+		c.Set(userContextKey, user)
+		if session != nil {
+			c.Set(sessionContextKey, session)
+		}
+		c.Next()
+	}
+}
+
+// authenticate returns the user of a bearer token or, failing that, the
+// user and session of the session cookie. It returns nil if the request
+// carries neither.
+func authenticate(c *gin.Context) (*models.User, *models.Session, error) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, nil, nil
+		}
+		user, err := tokenUser(strings.TrimSpace(token))
+		return user, nil, err
+	}
+
+	session, err := findSession(c)
+	if err != nil || session == nil {
+		return nil, nil, err
+	}
+	return &session.User, session, nil
+}
+
+// tokenUser returns the owner of an unexpired API token
+func tokenUser(token string) (*models.User, error) {
+	var apiToken models.APIToken
+	err := database.GetDB().Preload("User").Where("token_hash = ?", hashToken(token)).First(&apiToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API token: %w", err)
+	}
+
+	now := time.Now()
+	if apiToken.ExpiresAt != nil && apiToken.ExpiresAt.Before(now) {
+		return nil, nil
+	}
+
+	err = database.GetDB().Model(&apiToken).Update("last_used_at", now).Error
+	if err != nil {
+		log.Printf("Failed to record use of API token %d: %v", apiToken.ID, err)
+	}
+	return &apiToken.User, nil
+}
+
+// findSession returns the session of the request's cookie along with its
+// user, if the session hasn't expired
+func findSession(c *gin.Context) (*models.Session, error) {
+	token, err := c.Cookie(sessionCookie)
+	if err != nil || token == "" {
+		return nil, nil
+	}
+
+	var session models.Session
+	err = database.GetDB().Preload("User").
+		Where("token_hash = ? AND expires_at > ?", hashToken(token), time.Now()).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up session: %w", err)
+	}
+
+	// Sessions started before CSRF protection get their token now
+	if session.CSRFToken == "" {
+		if session.CSRFToken, err = newToken(); err != nil {
+			return nil, err
+		}
+		if err := database.GetDB().Model(&session).Update("csrf_token", session.CSRFToken).Error; err != nil {
+			return nil, fmt.Errorf("failed to save CSRF token: %w", err)
+		}
+	}
+	return &session, nil
+}
+
+// currentUser returns the user authenticated by requireAuth
+func currentUser(c *gin.Context) *models.User {
+	if user, ok := c.Get(userContextKey); ok {
+		return user.(*models.User)
+	}
+	return nil
+}
+
+// currentSession returns the session authenticated by requireAuth, or nil
+// for requests authenticated with an API token
+func currentSession(c *gin.Context) *models.Session {
+	if session, ok := c.Get(sessionContextKey); ok {
+		return session.(*models.Session)
+	}
+	return nil
+}
+
+// checkPassword returns the user with the given credentials, or nil if they
+// don't match
+func checkPassword(username, password string) (*models.User, error) {
+	var user models.User
+	err := database.GetDB().Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// startSession creates a session for user and sets its cookie
+func startSession(c *gin.Context, user *models.User) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	csrfToken, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := sessionTTL()
+	session := models.Session{
+		TokenHash: hashToken(token),
+		CSRFToken: csrfToken,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := database.GetDB().Create(&session).Error; err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	// Clean up sessions that ran out while we're at it
+	if err := database.GetDB().Where("expires_at < ?", time.Now()).Delete(&models.Session{}).Error; err != nil {
+		log.Printf("Failed to delete expired sessions: %v", err)
+	}
+
+	setSessionCookie(c, token, int(ttl.Seconds()))
+	return nil
+}
+
+// setSessionCookie sets the session cookie, or clears it when maxAge is
+// negative. The cookie is only sent over HTTPS when the request came in over
+// HTTPS, directly or through a proxy.
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", secure, true)
+}
+
+// sessionTTL returns how long sessions last, from DOCKFORMER_SESSION_TTL
+func sessionTTL() time.Duration {
+	value := os.Getenv("DOCKFORMER_SESSION_TTL")
+	if value == "" {
+		return defaultSessionTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		log.Printf("Invalid DOCKFORMER_SESSION_TTL '%s', using %s", value, defaultSessionTTL)
+		return defaultSessionTTL
+	}
+	return ttl
+}
+
+// bootstrapAdmin makes sure there is an admin. When there is none, the
+// user named by DOCKFORMER_ADMIN_USER is made admin, or created with
+// DOCKFORMER_ADMIN_PASSWORD if it doesn't exist yet.
+func bootstrapAdmin() error {
+	var count int64
+	if err := database.GetDB().Model(&models.User{}).Where("role = ?", models.RoleAdmin).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	username := os.Getenv("DOCKFORMER_ADMIN_USER")
+	if username == "" {
+		username = defaultAdminUser
+	}
+
+	// Accounts created before roles existed start out as viewers
+	result := database.GetDB().Model(&models.User{}).Where("username = ?", username).Update("role", models.RoleAdmin)
+	if result.Error != nil {
+		return fmt.Errorf("failed to promote bootstrap admin: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Made '%s' an admin", username)
+		return nil
+	}
+
+	password := os.Getenv("DOCKFORMER_ADMIN_PASSWORD")
+	if password == "" {
+		log.Println("Warning: no admin exists and DOCKFORMER_ADMIN_PASSWORD is not set, nobody can manage DockFormer")
+		return nil
+	}
+
+	user, err := newUser(username, password, models.RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap admin: %w", err)
+	}
+	if err := database.GetDB().Create(user).Error; err != nil {
+		return fmt.Errorf("failed to create bootstrap admin: %w", err)
+	}
+
+	log.Printf("Created bootstrap admin '%s'", username)
+	return nil
+}
+
+// newUser validates a username, password and role and hashes the password
+func newUser(username, password string, role models.Role) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username must not be empty")
+	}
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	if !role.Valid() {
+		return nil, errors.New("role must be 'viewer', 'operator' or 'admin'")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	return &models.User{Username: username, PasswordHash: string(hash), Role: role}, nil
+}
+
+// newToken returns a random token for a session or API token
+func newToken() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// hashToken returns the hash under which a token is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isAPIRequest reports whether a request is made by a program rather than a
+// browser navigating the UI
+func isAPIRequest(c *gin.Context) bool {
+	path := c.Request.URL.Path
+	return strings.HasPrefix(path, "/api/") || path == "/metrics"
+}
+
+// safeRedirect returns next if it is a path on this server, so the login
+// form can't be used to send users elsewhere
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// userResponse converts a user for the API, leaving out the password hash
+func userResponse(user models.User) UserResponse {
+	return UserResponse{ID: user.ID, Username: user.Username, Role: user.Role, CreatedAt: user.CreatedAt}
+}
+
+// tokenResponse converts an API token for the API, leaving out its hash
+func tokenResponse(token models.APIToken) TokenResponse {
+	return TokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
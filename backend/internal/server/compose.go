@@ -0,0 +1,488 @@
+package server
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// composeConditions maps Compose depends_on conditions to DockFormer's
+var composeConditions = map[string]string{
+	"service_started":                ConditionStarted,
+	"service_healthy":                ConditionHealthy,
+	"service_completed_successfully": ConditionCompletedSuccessfully,
+}
+
+// composeEnvironment is the kind of key-value pairs taken from a service's
+// environment
+const composeEnvironment = "environment variable"
+
+// composeLoader translates a Docker Compose document into a ContainersConfig,
+// collecting every unsupported key and conversion problem along the way
+type composeLoader struct {
+	errs ValidationErrors
+}
+
+// Helper functions
+
+// isComposeDocument reports whether a parsed YAML document uses the Compose
+// "services:" format rather than DockFormer's "containers:" list
+func isComposeDocument(doc *yaml.Node) bool {
+	root := documentRoot(doc)
+	if root == nil || root.Kind != yaml.MappingNode {
+		return false
+	}
+	return mappingValue(root, "services") != nil && mappingValue(root, "containers") == nil
+}
+
+// loadCompose translates a Compose document into a ContainersConfig. Keys
+// DockFormer can't honour are reported with their position instead of being
+// ignored.
+func loadCompose(doc *yaml.Node) (ContainersConfig, ValidationErrors) {
+	loader := &composeLoader{}
+	config := ContainersConfig{}
+
+	root := documentRoot(doc)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+		switch key {
+		case "version":
+			// Obsolete in the Compose specification, nothing to translate
+		case "name":
+			loader.decode(value, key, &config.Name)
+		case "services":
+			config.Containers = loader.services(value)
+		case "networks":
+			config.Networks = loader.networks(value)
+		case "volumes":
+			loader.volumes(value)
+		default:
+			loader.unsupportedKey(root.Content[i], key)
+		}
+	}
+
+	return config, loader.errs
+}
+
+// unsupportedKey records a Compose key DockFormer can't honour
+func (l *composeLoader) unsupportedKey(key *yaml.Node, path string) {
+	l.errs.add(key, path, "unsupported Compose key")
+}
+
+// problem records a value that is supported but couldn't be translated
+func (l *composeLoader) problem(node *yaml.Node, path string, format string, args ...any) {
+	l.errs.add(node, path, fmt.Sprintf(format, args...))
+}
+
+// decode decodes a node into target, recording a problem on failure
+func (l *composeLoader) decode(node *yaml.Node, path string, target any) bool {
+	if err := node.Decode(target); err != nil {
+		l.problem(node, path, "%s", decodeMessage(err))
+		return false
+	}
+	return true
+}
+
+// services translates the Compose services section, in file order
+func (l *composeLoader) services(node *yaml.Node) []ContainerConfig {
+	if node.Kind != yaml.MappingNode {
+		l.problem(node, "services", "expected a mapping of service names")
+		return nil
+	}
+
+	var containers []ContainerConfig
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		containers = append(containers, l.service("services."+name, name, node.Content[i+1]))
+	}
+	return containers
+}
+
+// service translates a single Compose service into a ContainerConfig
+func (l *composeLoader) service(path string, name string, node *yaml.Node) ContainerConfig {
+	config := ContainerConfig{Name: name}
+	if node.Kind != yaml.MappingNode {
+		l.problem(node, path, "expected a mapping")
+		return config
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		keyPath := path + "." + key
+
+		switch key {
+		case "image":
+			l.decode(value, keyPath, &config.Image)
+		case "container_name":
+			l.decode(value, keyPath, &config.Name)
+		case "ports":
+			config.Ports = l.ports(keyPath, value)
+		case "environment":
+			config.Env = l.keyValues(keyPath, value, composeEnvironment)
+		case "labels":
+			config.Labels = l.keyValues(keyPath, value, "label")
+		case "volumes":
+			config.Volumes = l.serviceVolumes(keyPath, value)
+		case "command":
+			config.Command = l.command(keyPath, value)
+		case "networks":
+			config.Networks = l.serviceNetworks(keyPath, value)
+		case "depends_on":
+			config.DependsOn = l.dependsOn(keyPath, value)
+		case "restart":
+			l.decode(value, keyPath, &config.Restart)
+		case "healthcheck":
+			config.Healthcheck = l.healthcheck(keyPath, value)
+		case "mem_limit":
+			l.decode(value, keyPath, &config.Memory)
+		case "mem_reservation":
+			l.decode(value, keyPath, &config.MemoryReservation)
+		case "cpus":
+			l.decode(value, keyPath, &config.CPUs)
+		case "cpu_shares":
+			l.decode(value, keyPath, &config.CPUShares)
+		case "pids_limit":
+			l.decode(value, keyPath, &config.PidsLimit)
+		case "ulimits":
+			l.decode(value, keyPath, &config.Ulimits)
+		default:
+			l.unsupportedKey(node.Content[i], keyPath)
+		}
+	}
+	return config
+}
+
+// ports translates the short "HOST:CONTAINER[/PROTO]" and the long port
+// syntax into DockFormer's comma separated list
+func (l *composeLoader) ports(path string, node *yaml.Node) string {
+	if node.Kind != yaml.SequenceNode {
+		l.problem(node, path, "expected a list")
+		return ""
+	}
+
+	var ports []string
+	for i, item := range node.Content {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+
+		if item.Kind == yaml.MappingNode {
+			var long struct {
+				Target    string `yaml:"target"`
+				Published string `yaml:"published"`
+				Protocol  string `yaml:"protocol"`
+			}
+			if !l.decode(item, itemPath, &long) {
+				continue
+			}
+			if long.Target == "" || long.Published == "" {
+				l.problem(item, itemPath, "target and published are required")
+				continue
+			}
+			port := long.Published + ":" + long.Target
+			if long.Protocol != "" {
+				port += "/" + long.Protocol
+			}
+			ports = append(ports, port)
+			continue
+		}
+
+		var port string
+		if !l.decode(item, itemPath, &port) {
+			continue
+		}
+		if strings.Count(port, ":") != 1 {
+			l.problem(item, itemPath, "only HOST:CONTAINER port mappings are supported, got '%s'", port)
+			continue
+		}
+		ports = append(ports, port)
+	}
+	return strings.Join(ports, ",")
+}
+
+// keyValues translates an environment or labels list ("KEY=VALUE") or map.
+// Compose takes environment variables without a value, "KEY" in a list or
+// "KEY:" in a map, from the shell running it. DockFormer has no such shell,
+// so they are rejected rather than silently set empty; labels without a
+// value are empty.
+func (l *composeLoader) keyValues(path string, node *yaml.Node, kind string) map[string]string {
+	env := make(map[string]string)
+	unset := func(item *yaml.Node, key string) {
+		if kind == composeEnvironment {
+			l.problem(item, path, "%s '%s' has no value, set one or use \"\" for an empty value", kind, key)
+			return
+		}
+		env[key] = ""
+	}
+
+	if node.Kind == yaml.SequenceNode {
+		var entries []string
+		if !l.decode(node, path, &entries) {
+			return nil
+		}
+		for i, entry := range entries {
+			key, value, hasValue := strings.Cut(entry, "=")
+			if _, ok := env[key]; ok {
+				l.problem(node.Content[i], path, "duplicate %s '%s'", kind, key)
+			}
+			if !hasValue {
+				unset(node.Content[i], key)
+				continue
+			}
+			env[key] = value
+		}
+		return env
+	}
+
+	if node.Kind != yaml.MappingNode {
+		l.problem(node, path, "expected a list or a mapping")
+		return nil
+	}
+
+	// Map values may be numbers, booleans or empty, keep them as written
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		if value.Tag == "!!null" {
+			unset(node.Content[i], key)
+			continue
+		}
+		env[key] = value.Value
+	}
+	return env
+}
+
+// serviceVolumes translates short "SRC:DST[:MODE]" and long bind mount syntax
+func (l *composeLoader) serviceVolumes(path string, node *yaml.Node) []string {
+	if node.Kind != yaml.SequenceNode {
+		l.problem(node, path, "expected a list")
+		return nil
+	}
+
+	var volumes []string
+	for i, item := range node.Content {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+
+		if item.Kind == yaml.MappingNode {
+			var long struct {
+				Type     string `yaml:"type"`
+				Source   string `yaml:"source"`
+				Target   string `yaml:"target"`
+				ReadOnly bool   `yaml:"read_only"`
+			}
+			if !l.decode(item, itemPath, &long) {
+				continue
+			}
+			if long.Type != "bind" && long.Type != "volume" {
+				l.problem(item, itemPath, "only bind and volume mounts are supported, got '%s'", long.Type)
+				continue
+			}
+			volume := long.Source + ":" + long.Target
+			if long.ReadOnly {
+				volume += ":ro"
+			}
+			volumes = append(volumes, volume)
+			continue
+		}
+
+		var volume string
+		if l.decode(item, itemPath, &volume) {
+			volumes = append(volumes, volume)
+		}
+	}
+	return volumes
+}
+
+// command translates a command given as a string or a list
+func (l *composeLoader) command(path string, node *yaml.Node) string {
+	if node.Kind != yaml.SequenceNode {
+		var command string
+		l.decode(node, path, &command)
+		return command
+	}
+
+	var args []string
+	if !l.decode(node, path, &args) {
+		return ""
+	}
+	for _, arg := range args {
+		// Commands are split on spaces when the container is created
+		if strings.ContainsAny(arg, " \t") {
+			l.problem(node, path, "arguments containing spaces are not supported, got '%s'", arg)
+			return ""
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// serviceNetworks translates a service's network list or map. Per-network
+// options such as aliases or static addresses are not supported.
+func (l *composeLoader) serviceNetworks(path string, node *yaml.Node) []string {
+	if node.Kind == yaml.SequenceNode {
+		var networks []string
+		l.decode(node, path, &networks)
+		return networks
+	}
+	if node.Kind != yaml.MappingNode {
+		l.problem(node, path, "expected a list or a mapping")
+		return nil
+	}
+
+	var networks []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, options := node.Content[i].Value, node.Content[i+1]
+		networks = append(networks, name)
+		if options.Kind == yaml.MappingNode {
+			for j := 0; j+1 < len(options.Content); j += 2 {
+				l.unsupportedKey(options.Content[j], path+"."+name+"."+options.Content[j].Value)
+			}
+		}
+	}
+	return networks
+}
+
+// dependsOn translates a depends_on list or map of conditions
+func (l *composeLoader) dependsOn(path string, node *yaml.Node) DependsOn {
+	if node.Kind == yaml.SequenceNode {
+		var deps DependsOn
+		l.decode(node, path, &deps)
+		return deps
+	}
+	if node.Kind != yaml.MappingNode {
+		l.problem(node, path, "expected a list or a mapping")
+		return nil
+	}
+
+	deps := make(DependsOn)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, options := node.Content[i].Value, node.Content[i+1]
+		dep := Dependency{Condition: ConditionStarted}
+
+		for j := 0; j+1 < len(options.Content); j += 2 {
+			key, value := options.Content[j].Value, options.Content[j+1]
+			if key != "condition" {
+				l.unsupportedKey(options.Content[j], path+"."+name+"."+key)
+				continue
+			}
+			condition, ok := composeConditions[value.Value]
+			if !ok {
+				l.problem(value, path+"."+name+".condition", "unknown condition '%s'", value.Value)
+				continue
+			}
+			dep.Condition = condition
+		}
+		deps[name] = dep
+	}
+	return deps
+}
+
+// healthcheck translates a healthcheck, whose keys match DockFormer's own
+func (l *composeLoader) healthcheck(path string, node *yaml.Node) *HealthcheckConfig {
+	if node.Kind != yaml.MappingNode {
+		l.problem(node, path, "expected a mapping")
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		switch key := node.Content[i].Value; key {
+		case "test", "interval", "timeout", "retries", "start_period":
+		default:
+			l.unsupportedKey(node.Content[i], path+"."+key)
+		}
+	}
+
+	var healthcheck HealthcheckConfig
+	if !l.decode(node, path, &healthcheck) {
+		return nil
+	}
+	return &healthcheck
+}
+
+// networks translates the top-level networks section
+func (l *composeLoader) networks(node *yaml.Node) map[string]NetworkConfig {
+	if node.Kind != yaml.MappingNode {
+		l.problem(node, "networks", "expected a mapping of network names")
+		return nil
+	}
+
+	networks := make(map[string]NetworkConfig)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, options := node.Content[i].Value, node.Content[i+1]
+		path := "networks." + name
+		var networkConfig NetworkConfig
+
+		for j := 0; j+1 < len(options.Content); j += 2 {
+			key, value := options.Content[j].Value, options.Content[j+1]
+			switch key {
+			case "driver":
+				l.decode(value, path+".driver", &networkConfig.Driver)
+			case "internal":
+				l.decode(value, path+".internal", &networkConfig.Internal)
+			case "external":
+				// Existing networks are used as they are
+				l.decode(value, path+".external", &networkConfig.External)
+			case "ipam":
+				networkConfig.Subnet = l.ipamSubnet(path+".ipam", value)
+			default:
+				l.unsupportedKey(options.Content[j], path+"."+key)
+			}
+		}
+		networks[name] = networkConfig
+	}
+	return networks
+}
+
+// ipamSubnet extracts the single subnet DockFormer supports from an ipam block
+func (l *composeLoader) ipamSubnet(path string, node *yaml.Node) string {
+	var ipam struct {
+		Config []map[string]string `yaml:"config"`
+	}
+	if !l.decode(node, path, &ipam) {
+		return ""
+	}
+	if len(ipam.Config) > 1 {
+		l.problem(node, path+".config", "only a single subnet is supported")
+	}
+	if len(ipam.Config) == 0 {
+		return ""
+	}
+
+	for key := range ipam.Config[0] {
+		if key != "subnet" {
+			l.unsupportedKey(node, path+".config[0]."+key)
+		}
+	}
+	return ipam.Config[0]["subnet"]
+}
+
+// volumes checks the top-level volumes section. Named volumes are created by
+// Docker on first use, so only declarations without options are supported.
+func (l *composeLoader) volumes(node *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, options := node.Content[i].Value, node.Content[i+1]
+		for j := 0; j+1 < len(options.Content); j += 2 {
+			l.unsupportedKey(options.Content[j], "volumes."+name+"."+options.Content[j].Value)
+		}
+	}
+}
+
+// documentRoot returns the top-level node of a parsed YAML document
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == 0 {
+		return nil
+	}
+	if doc.Kind == yaml.DocumentNode {
+		if len(doc.Content) == 0 {
+			return nil
+		}
+		return doc.Content[0]
+	}
+	return doc
+}
+
+// mappingValue returns the value stored under key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
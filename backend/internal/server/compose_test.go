@@ -0,0 +1,221 @@
+package server
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"testing"
+)
+
+// parseCompose parses a Compose document and translates it
+func parseCompose(t *testing.T, document string) (ContainersConfig, ValidationErrors) {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(document), &doc); err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+	if !isComposeDocument(&doc) {
+		t.Fatal("expected a Compose document")
+	}
+	return loadCompose(&doc)
+}
+
+func TestLoadCompose(t *testing.T) {
+	config, errs := parseCompose(t, `version: "3.8"
+name: shop
+services:
+  app:
+    image: shop/app:1.0
+    container_name: shop-app
+    ports:
+      - "8080:80"
+      - target: 53
+        published: 5353
+        protocol: udp
+    environment:
+      - MODE=production
+      - GREETING=a=b
+      - EMPTY=
+    labels:
+      team: shop
+      managed:
+    volumes:
+      - /srv/shop:/data:ro
+      - type: volume
+        source: cache
+        target: /cache
+        read_only: true
+    command: ["serve", "--port", "80"]
+    networks: [backend, shared]
+    depends_on:
+      db:
+        condition: service_healthy
+    restart: unless-stopped
+    mem_limit: 256m
+    cpus: "0.5"
+  db:
+    image: postgres:16
+    environment:
+      POSTGRES_PORT: 5432
+      POSTGRES_HOST_AUTH: ""
+    healthcheck:
+      test: pg_isready
+      interval: 5s
+      retries: 3
+networks:
+  backend:
+    driver: bridge
+    ipam:
+      config:
+        - subnet: 172.28.0.0/16
+  shared:
+    external: true
+volumes:
+  cache:
+`)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expected := ContainersConfig{
+		Name: "shop",
+		Containers: []ContainerConfig{
+			{
+				Name:      "shop-app",
+				Image:     "shop/app:1.0",
+				Ports:     "8080:80,5353:53/udp",
+				Env:       map[string]string{"MODE": "production", "GREETING": "a=b", "EMPTY": ""},
+				Labels:    map[string]string{"team": "shop", "managed": ""},
+				Volumes:   []string{"/srv/shop:/data:ro", "cache:/cache:ro"},
+				Command:   "serve --port 80",
+				Networks:  []string{"backend", "shared"},
+				DependsOn: DependsOn{"db": {Condition: ConditionHealthy}},
+				Restart:   "unless-stopped",
+				Memory:    "256m",
+				CPUs:      "0.5",
+			},
+			{
+				Name:  "db",
+				Image: "postgres:16",
+				Env:   map[string]string{"POSTGRES_PORT": "5432", "POSTGRES_HOST_AUTH": ""},
+				Healthcheck: &HealthcheckConfig{
+					Test:     HealthcheckTest{"CMD-SHELL", "pg_isready"},
+					Interval: "5s",
+					Retries:  3,
+				},
+			},
+		},
+		Networks: map[string]NetworkConfig{
+			"backend": {Driver: "bridge", Subnet: "172.28.0.0/16"},
+			"shared":  {External: true},
+		},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected\n%+v\ngot\n%+v", expected, config)
+	}
+}
+
+func TestLoadComposeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		expected []string
+	}{
+		{
+			name: "unsupported keys",
+			yaml: `services:
+  app:
+    image: shop/app:1.0
+    build: .
+    deploy:
+      replicas: 2
+secrets:
+  token:
+    file: ./token
+`,
+			expected: []string{
+				"line 4, column 5: services.app.build: unsupported Compose key",
+				"line 5, column 5: services.app.deploy: unsupported Compose key",
+				"line 7, column 1: secrets: unsupported Compose key",
+			},
+		},
+		{
+			name: "environment list without value",
+			yaml: `services:
+  app:
+    image: shop/app:1.0
+    environment:
+      - MODE=production
+      - API_TOKEN
+`,
+			expected: []string{`line 6, column 9: services.app.environment: environment variable 'API_TOKEN' has no value, set one or use "" for an empty value`},
+		},
+		{
+			name: "environment map without value",
+			yaml: `services:
+  app:
+    image: shop/app:1.0
+    environment:
+      API_TOKEN:
+`,
+			expected: []string{`line 5, column 7: services.app.environment: environment variable 'API_TOKEN' has no value, set one or use "" for an empty value`},
+		},
+		{
+			name: "duplicate environment variable",
+			yaml: `services:
+  app:
+    image: shop/app:1.0
+    environment:
+      - MODE=production
+      - MODE=debug
+`,
+			expected: []string{"line 6, column 9: services.app.environment: duplicate environment variable 'MODE'"},
+		},
+		{
+			name: "port with host address",
+			yaml: `services:
+  app:
+    image: shop/app:1.0
+    ports:
+      - "127.0.0.1:8080:80"
+`,
+			expected: []string{"line 5, column 9: services.app.ports[0]: only HOST:CONTAINER port mappings are supported, got '127.0.0.1:8080:80'"},
+		},
+		{
+			name: "unknown condition",
+			yaml: `services:
+  app:
+    image: shop/app:1.0
+    depends_on:
+      db:
+        condition: service_ready
+  db:
+    image: postgres:16
+`,
+			expected: []string{"line 6, column 20: services.app.depends_on.db.condition: unknown condition 'service_ready'"},
+		},
+		{
+			name: "tmpfs mount",
+			yaml: `services:
+  app:
+    image: shop/app:1.0
+    volumes:
+      - type: tmpfs
+        target: /tmp
+`,
+			expected: []string{"line 5, column 9: services.app.volumes[0]: only bind and volume mounts are supported, got 'tmpfs'"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, errs := parseCompose(t, test.yaml)
+
+			var messages []string
+			for _, err := range errs {
+				messages = append(messages, err.Error())
+			}
+			if !reflect.DeepEqual(messages, test.expected) {
+				t.Errorf("expected errors\n%q\ngot\n%q", test.expected, messages)
+			}
+		})
+	}
+}
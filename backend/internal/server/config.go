@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+)
+
+// ContainersConfig represents a YAML file describing a stack of containers
+type ContainersConfig struct {
+	Name       string                   `yaml:"name,omitempty"`
+	Containers []ContainerConfig        `yaml:"containers"`
+	Networks   map[string]NetworkConfig `yaml:"networks,omitempty"`
+}
+
+// ContainerConfig represents the YAML configuration for container creation
+type ContainerConfig struct {
+	Name        string             `yaml:"name"`
+	Image       string             `yaml:"image"`
+	Ports       string             `yaml:"ports"`
+	Env         map[string]string  `yaml:"env,omitempty"`
+	Labels      map[string]string  `yaml:"labels,omitempty"`
+	Volumes     []string           `yaml:"volumes,omitempty"`
+	Command     string             `yaml:"command,omitempty"`
+	Networks    []string           `yaml:"networks,omitempty"`
+	DependsOn   DependsOn          `yaml:"depends_on,omitempty"`
+	Healthcheck *HealthcheckConfig `yaml:"healthcheck,omitempty"`
+
+	// Restart policy and resource limits
+	Restart           string                  `yaml:"restart,omitempty"`
+	Memory            string                  `yaml:"memory,omitempty"`
+	MemoryReservation string                  `yaml:"memory_reservation,omitempty"`
+	CPUs              string                  `yaml:"cpus,omitempty"`
+	CPUShares         int64                   `yaml:"cpu_shares,omitempty"`
+	PidsLimit         int64                   `yaml:"pids_limit,omitempty"`
+	Ulimits           map[string]UlimitConfig `yaml:"ulimits,omitempty"`
+}
+
+// stackName returns the stack name declared in the YAML, falling back to
+// defaultName when the file does not set one
+func (config ContainersConfig) stackName(defaultName string) string {
+	if config.Name != "" {
+		return config.Name
+	}
+	return defaultName
+}
+
+// parseContainersConfig parses raw YAML into a ContainersConfig. The file is
+// strictly validated first and every problem found is returned together as
+// ValidationErrors. Docker Compose files are detected from their "services:"
+// section and translated.
+func parseContainersConfig(yamlData []byte) (ContainersConfig, error) {
+	config, errs := loadContainersConfig(yamlData)
+	if len(errs) > 0 {
+		return ContainersConfig{}, errs
+	}
+	return config, nil
+}
+
+// readYamlRequest reads a YAML document either from the "yamlFile" multipart
+// form field or, for API clients, from the raw request body. It returns the
+// content and a default stack name derived from the file name.
+func readYamlRequest(c *gin.Context) ([]byte, string, error) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/") {
+		yamlData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to read request body: %w", err)
+		}
+		if len(yamlData) == 0 {
+			return nil, "", errors.New("Request body is empty")
+		}
+		return yamlData, c.DefaultQuery("stack", "default"), nil
+	}
+
+	// Get the file from the request
+	file, err := c.FormFile("yamlFile")
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to get file: %w", err)
+	}
+
+	// Validate file is a YAML file
+	ext := filepath.Ext(file.Filename)
+	if ext != ".yaml" && ext != ".yml" {
+		return nil, "", errors.New("File must be a YAML file (.yaml or .yml)")
+	}
+
+	// Open the uploaded file
+	openFile, err := file.Open()
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to open file: %w", err)
+	}
+	defer func(openFile multipart.File) {
+		err := openFile.Close()
+		if err != nil {
+			log.Printf("Failed to close file: %v", err)
+		}
+	}(openFile)
+
+	// Read the YAML content
+	yamlData, err := io.ReadAll(openFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read file: %w", err)
+	}
+
+	return yamlData, strings.TrimSuffix(filepath.Base(file.Filename), ext), nil
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// Where a CSRF token is accepted: the form field of HTML forms and the
+// header of requests made from JavaScript
+const (
+	csrfFormField = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// SessionResponse describes the signed-in browser session
+type SessionResponse struct {
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// Web UI handlers
+
+// postOnlyHandler answers the old GET links for container actions, which
+// let any page change container state by linking to them
+func postOnlyHandler(c *gin.Context) {
+	c.Header("Allow", http.MethodPost)
+	c.HTML(http.StatusMethodNotAllowed, "error.html", gin.H{
+		"error": "Container actions no longer accept GET requests. Use the buttons on the dashboard, " +
+			"or POST to /api/containers/:id/start, /stop or /restart with an API token.",
+	})
+}
+
+// API handlers
+func getSession(c *gin.Context) {
+	user := currentUser(c)
+	response := SessionResponse{Username: user.Username, Role: string(user.Role)}
+	if session := currentSession(c); session != nil {
+		response.CSRFToken = session.CSRFToken
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Helper functions
+
+// csrfProtect rejects state-changing requests made with a session cookie
+// unless they carry the session's CSRF token. Requests authenticated with
+// an API token can't be forged by another site and are let through.
+func csrfProtect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		session := currentSession(c)
+		if session == nil {
+			c.Next()
+			return
+		}
+
+		token := c.GetHeader(csrfHeader)
+		if token == "" {
+			token = c.PostForm(csrfFormField)
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+			abortWithError(c, http.StatusForbidden, "Invalid or missing CSRF token, reload the page and try again")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// csrfToken returns the CSRF token to embed in pages for the request's
+// session
+func csrfToken(c *gin.Context) string {
+	if session := currentSession(c); session != nil {
+		return session.CSRFToken
+	}
+	return ""
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gopkg.in/yaml.v3"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Conditions a container can wait for before its dependent is started
+const (
+	ConditionStarted               = "started"
+	ConditionHealthy               = "healthy"
+	ConditionCompletedSuccessfully = "completed_successfully"
+)
+
+// dependencyTimeout bounds how long a container started in the background
+// waits for its dependencies
+const dependencyTimeout = 2 * time.Minute
+
+// Dependency describes what a container waits for on one of its dependencies
+type Dependency struct {
+	Condition string `yaml:"condition,omitempty"`
+}
+
+// DependsOn maps dependency container names to the condition to wait for.
+// In YAML it is either a list of names, which wait for the dependency to be
+// started, or a map of names to a condition.
+type DependsOn map[string]Dependency
+
+// UnmarshalYAML accepts both the list and the map form of depends_on
+func (d *DependsOn) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		var names []string
+		if err := value.Decode(&names); err != nil {
+			return err
+		}
+		*d = make(DependsOn, len(names))
+		for _, name := range names {
+			(*d)[name] = Dependency{Condition: ConditionStarted}
+		}
+		return nil
+	}
+
+	var deps map[string]Dependency
+	if err := value.Decode(&deps); err != nil {
+		return err
+	}
+	for name, dep := range deps {
+		if dep.Condition == "" {
+			dep.Condition = ConditionStarted
+			deps[name] = dep
+		}
+	}
+	*d = deps
+	return nil
+}
+
+// cycleError reports a dependency cycle, listing the containers on it
+type cycleError struct {
+	names []string
+}
+
+// Error returns the cycle as a chain of container names
+func (e *cycleError) Error() string {
+	return "dependency cycle: " + strings.Join(e.names, " -> ")
+}
+
+// Helper functions
+
+// orderContainers sorts containers so every container comes after the ones
+// it depends on, keeping file order where dependencies allow it. Unknown
+// dependencies, invalid conditions and cycles are reported by container name.
+func orderContainers(containers []ContainerConfig) ([]ContainerConfig, error) {
+	byName := make(map[string]ContainerConfig, len(containers))
+	for _, containerConfig := range containers {
+		byName[containerConfig.Name] = containerConfig
+	}
+
+	// Validate every edge before walking the graph
+	for _, containerConfig := range containers {
+		for _, name := range containerConfig.dependencyNames() {
+			if _, ok := byName[name]; !ok {
+				return nil, fmt.Errorf("container '%s' depends on unknown container '%s'", containerConfig.Name, name)
+			}
+			switch condition := containerConfig.DependsOn[name].Condition; condition {
+			case ConditionStarted, ConditionHealthy, ConditionCompletedSuccessfully:
+			default:
+				return nil, fmt.Errorf("container '%s' has invalid condition '%s' for dependency '%s'", containerConfig.Name, condition, name)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(containers))
+	ordered := make([]ContainerConfig, 0, len(containers))
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			// Report the cycle starting from the first occurrence of name
+			for i, n := range path {
+				if n == name {
+					return &cycleError{names: append(append([]string{}, path[i:]...), name)}
+				}
+			}
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range byName[name].dependencyNames() {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+
+	for _, containerConfig := range containers {
+		if err := visit(containerConfig.Name); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// dependencyNames returns the container's dependencies in a stable order
+func (config ContainerConfig) dependencyNames() []string {
+	names := make([]string, 0, len(config.DependsOn))
+	for name := range config.DependsOn {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// orderStackContainers returns the stack's containers in dependency order
+// along with their configuration. Containers that are no longer part of the
+// stack's YAML are appended at the end. The YAML of stacks applied before a
+// validation rule was added may no longer parse, their containers are
+// returned in the order they were stored without configuration, so none of
+// them waits.
+func orderStackContainers(stack *models.Stack) ([]*models.Container, map[string]ContainerConfig) {
+	config, err := parseContainersConfig([]byte(stack.SourceYAML))
+	var ordered []ContainerConfig
+	if err == nil {
+		ordered, err = orderContainers(config.Containers)
+	}
+	if err != nil {
+		log.Printf("Ignoring dependencies of stack %s, its YAML no longer parses: %v", stack.Name, err)
+	}
+
+	byName := make(map[string]*models.Container, len(stack.Containers))
+	for i := range stack.Containers {
+		byName[stack.Containers[i].Name] = &stack.Containers[i]
+	}
+
+	configs := make(map[string]ContainerConfig, len(ordered))
+	result := make([]*models.Container, 0, len(stack.Containers))
+	for _, containerConfig := range ordered {
+		configs[containerConfig.Name] = containerConfig
+		if containerObj, ok := byName[containerConfig.Name]; ok {
+			result = append(result, containerObj)
+			delete(byName, containerConfig.Name)
+		}
+	}
+	for i := range stack.Containers {
+		if _, ok := byName[stack.Containers[i].Name]; ok {
+			result = append(result, &stack.Containers[i])
+		}
+	}
+
+	return result, configs
+}
+
+// waitForDependencies blocks until every dependency of the container has
+// reached its condition, failing early when one can no longer get there
+func waitForDependencies(ctx context.Context, config ContainerConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+
+	for _, name := range config.dependencyNames() {
+		condition := config.DependsOn[name].Condition
+		for {
+			met, err := dependencyMet(ctx, name, condition)
+			if err != nil {
+				return fmt.Errorf("dependency '%s' of '%s': %w", name, config.Name, err)
+			}
+			if met {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("timed out waiting for '%s' to be %s", name, condition)
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	return nil
+}
+
+// dependenciesReady reports whether every dependency of the container has
+// already reached its condition, failing when one can no longer get there
+func dependenciesReady(ctx context.Context, config ContainerConfig) (bool, error) {
+	for _, name := range config.dependencyNames() {
+		met, err := dependencyMet(ctx, name, config.DependsOn[name].Condition)
+		if err != nil {
+			return false, fmt.Errorf("dependency '%s' of '%s': %w", name, config.Name, err)
+		}
+		if !met {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// dependencyMet reports whether a container currently satisfies a condition
+func dependencyMet(ctx context.Context, name string, condition string) (bool, error) {
+	info, err := dockerClient.ContainerInspect(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	state := info.State
+
+	switch condition {
+	case ConditionHealthy:
+		if state.Health == nil {
+			return false, errors.New("container has no healthcheck")
+		}
+		if state.Health.Status == "unhealthy" {
+			return false, errors.New("container is unhealthy")
+		}
+		return state.Health.Status == "healthy", nil
+	case ConditionCompletedSuccessfully:
+		if state.Running || state.Status == "created" {
+			return false, nil
+		}
+		if state.ExitCode != 0 {
+			return false, fmt.Errorf("container exited with code %d", state.ExitCode)
+		}
+		return true, nil
+	default:
+		// Any state past "created" means the container has been started
+		return state.Status != "created", nil
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"github.com/hspgit/DockFormer/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestOrderStackContainers(t *testing.T) {
+	containers := []models.Container{{Name: "app"}, {Name: "db"}, {Name: "old"}}
+
+	tests := []struct {
+		name     string
+		yaml     string
+		expected []string
+		waits    bool
+	}{
+		{
+			name:     "dependency order",
+			yaml:     dependentStack,
+			expected: []string{"db", "app", "old"},
+			waits:    true,
+		},
+		{
+			name:     "YAML rejected by newer validation",
+			yaml:     "containers:\n  - name: app\n    image: shop/app:1.0\n    retired_field: true\n  - name: db\n    image: postgres:16\n",
+			expected: []string{"app", "db", "old"},
+		},
+		{
+			name:     "dependency cycle",
+			yaml:     "containers:\n  - name: app\n    image: shop/app:1.0\n    depends_on: [db]\n  - name: db\n    image: postgres:16\n    depends_on: [app]\n",
+			expected: []string{"app", "db", "old"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stack := &models.Stack{Name: "shop", SourceYAML: test.yaml, Containers: append([]models.Container{}, containers...)}
+			ordered, configs := orderStackContainers(stack)
+
+			names := make([]string, len(ordered))
+			for i, containerObj := range ordered {
+				names[i] = containerObj.Name
+			}
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, names)
+			}
+			if waits := len(configs["app"].DependsOn) > 0; waits != test.waits {
+				t.Errorf("expected app to wait %v, got %v", test.waits, waits)
+			}
+		})
+	}
+}
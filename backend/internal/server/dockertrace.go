@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Docker client traces every API request through OpenTelemetry. Rather
+// than wrapping its transport, which the client inspects for TLS and
+// dialing settings, DockFormer hands it a tracer provider whose spans only
+// record request metrics.
+
+// dockerAPIVersion matches the API version prefix of Docker request paths
+var dockerAPIVersion = regexp.MustCompile(`^/v[0-9.]+`)
+
+// dockerTracerProvider creates tracers that time Docker API requests
+type dockerTracerProvider struct {
+	embedded.TracerProvider
+}
+
+// Tracer returns a tracer that times Docker API requests
+func (dockerTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return dockerTracer{}
+}
+
+// dockerTracer starts a metricsSpan per Docker API request
+type dockerTracer struct {
+	embedded.Tracer
+}
+
+// Start begins timing a request. The span name is "METHOD /path".
+func (dockerTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	method, path, _ := strings.Cut(name, " ")
+	span := &metricsSpan{method: method, endpoint: dockerEndpoint(path), start: time.Now()}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// metricsSpan records a Docker API request in the metrics. The status is set
+// as soon as the response headers arrive, while the span only ends once the
+// body is read, so the request is recorded with the first status.
+type metricsSpan struct {
+	noop.Span
+	method   string
+	endpoint string
+	start    time.Time
+
+	once sync.Once
+}
+
+// IsRecording reports true so the status is set on the span
+func (s *metricsSpan) IsRecording() bool {
+	return true
+}
+
+// SetStatus records the request with its latency and outcome
+func (s *metricsSpan) SetStatus(code codes.Code, _ string) {
+	s.record(code == codes.Error)
+}
+
+// End records the request if no status was ever set
+func (s *metricsSpan) End(...trace.SpanEndOption) {
+	s.record(false)
+}
+
+// record adds the request to the metrics once
+func (s *metricsSpan) record(failed bool) {
+	s.once.Do(func() {
+		dockerRequests.inc(s.method, s.endpoint)
+		dockerDuration.observe(time.Since(s.start).Seconds(), s.method, s.endpoint)
+		if failed {
+			dockerErrors.inc(s.method, s.endpoint)
+		}
+	})
+}
+
+// dockerEndpoint turns a request path into a low-cardinality endpoint by
+// dropping the API version and replacing object names and IDs, e.g.
+// "/v1.47/containers/web/json" becomes "/containers/{id}/json"
+func dockerEndpoint(path string) string {
+	path = dockerAPIVersion.ReplaceAllString(path, "")
+
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments)-1; i++ {
+		next := segments[i+1]
+		switch segments[i] {
+		case "containers", "exec", "networks", "volumes":
+			if next != "json" && next != "create" && next != "prune" {
+				segments[i+1] = "{id}"
+				i++
+			}
+		case "images":
+			// Image names contain slashes, everything up to the action is the name
+			if next != "json" && next != "create" && next != "prune" && next != "search" {
+				endpoint := strings.Join(segments[:i+1], "/") + "/{name}"
+				switch action := segments[len(segments)-1]; action {
+				case "json", "history", "push", "tag", "get":
+					endpoint += "/" + action
+				}
+				return endpoint
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// Bounds of the delay before reconnecting to the Docker event stream
+const (
+	eventsRetryMin = time.Second
+	eventsRetryMax = time.Minute
+)
+
+// watchDockerEvents keeps the status of every container in the database in
+// step with Docker until ctx is cancelled. When the event stream breaks it
+// reconnects with exponential backoff and resynchronizes all containers, as
+// events may have been missed in between.
+func watchDockerEvents(ctx context.Context) {
+	backoff := eventsRetryMin
+	for {
+		err := streamDockerEvents(ctx, func() { backoff = eventsRetryMin })
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Docker event stream disconnected: %v, reconnecting in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, eventsRetryMax)
+	}
+}
+
+// streamDockerEvents subscribes to container events, resynchronizes the
+// database and then applies events as they arrive. connected is called once
+// the subscription is in place. It returns when the stream fails.
+func streamDockerEvents(ctx context.Context, connected func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Subscribe before resynchronizing so nothing falls in between
+	messages, errs := dockerClient.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("type", string(events.ContainerEventType))),
+	})
+
+	if err := resyncContainerStates(ctx); err != nil {
+		return err
+	}
+	connected()
+
+	for {
+		select {
+		case msg := <-messages:
+			handleContainerEvent(ctx, msg)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// handleContainerEvent updates the container an event refers to
+func handleContainerEvent(ctx context.Context, msg events.Message) {
+	switch {
+	case msg.Action == events.ActionDestroy:
+		// Only mark the row whose Docker container went away, not one that
+		// was recreated under the same name
+		err := database.GetDB().Model(&models.Container{}).
+			Where("container_id = ?", msg.Actor.ID).
+			Update("status", models.StatusRemoved).Error
+		if err != nil {
+			log.Printf("Failed to mark container %s as removed: %v", msg.Actor.ID, err)
+		}
+		stats.forget(msg.Actor.Attributes["name"])
+		return
+	case msg.Action == events.ActionRename:
+		// Samples recorded under the old name belong to no container now
+		stats.forget(strings.TrimPrefix(msg.Actor.Attributes["oldName"], "/"))
+		return
+	case msg.Action == events.ActionCreate,
+		msg.Action == events.ActionStart,
+		msg.Action == events.ActionRestart,
+		msg.Action == events.ActionStop,
+		msg.Action == events.ActionKill,
+		msg.Action == events.ActionDie,
+		msg.Action == events.ActionOOM,
+		msg.Action == events.ActionPause,
+		msg.Action == events.ActionUnPause,
+		strings.HasPrefix(string(msg.Action), string(events.ActionHealthStatus)):
+	default:
+		return
+	}
+
+	var containerObj models.Container
+	name := msg.Actor.Attributes["name"]
+	if err := database.GetDB().Where("name = ?", name).First(&containerObj).Error; err != nil {
+		// Not a container we manage
+		return
+	}
+
+	info, err := dockerClient.ContainerInspect(ctx, msg.Actor.ID)
+	if err != nil {
+		log.Printf("Failed to inspect container %s after %s event: %v", name, msg.Action, err)
+		return
+	}
+
+	// Guard on the Docker ID so events from a container that is being
+	// replaced don't overwrite the state of its replacement
+	err = database.GetDB().Model(&models.Container{}).
+		Where("id = ? AND container_id = ?", containerObj.ID, info.ID).
+		Updates(containerStateUpdates(info)).Error
+	if err != nil {
+		log.Printf("Failed to update state of container %s: %v", name, err)
+	}
+}
+
+// resyncContainerStates refreshes the state of every container in the
+// database from Docker
+func resyncContainerStates(ctx context.Context) error {
+	var containerList []models.Container
+	if err := database.GetDB().Find(&containerList).Error; err != nil {
+		return fmt.Errorf("failed to load containers: %w", err)
+	}
+
+	for _, containerObj := range containerList {
+		updates := map[string]interface{}{"status": models.StatusRemoved}
+
+		info, err := dockerClient.ContainerInspect(ctx, containerObj.Name)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to inspect container '%s': %w", containerObj.Name, err)
+		}
+		if err == nil {
+			updates = containerStateUpdates(info)
+			updates["container_id"] = info.ID
+		}
+
+		if err := database.GetDB().Model(&containerObj).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update container '%s': %w", containerObj.Name, err)
+		}
+	}
+	return nil
+}
+
+// containerStateUpdates returns the columns of a container row that mirror
+// its Docker state
+func containerStateUpdates(info container.InspectResponse) map[string]interface{} {
+	state := info.State
+	health, output := healthFromState(state)
+	return map[string]interface{}{
+		"status":        models.ContainerStatus(state.Status),
+		"health":        health,
+		"health_output": output,
+		"exit_code":     state.ExitCode,
+		"started_at":    parseDockerTime(state.StartedAt),
+		"finished_at":   parseDockerTime(state.FinishedAt),
+		"restart_count": info.RestartCount,
+	}
+}
+
+// parseDockerTime parses a timestamp from a container's state. Docker
+// reports events that haven't happened yet as the zero time, which is
+// returned as nil.
+func parseDockerTime(value string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.IsZero() {
+		return nil
+	}
+	return &t
+}
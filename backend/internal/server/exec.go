@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"github.com/docker/docker/api/types/container"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultExecShell is used when neither the request nor DOCKFORMER_EXEC_SHELL
+// names a shell
+const defaultExecShell = "/bin/sh"
+
+// execUpgrader upgrades exec requests to WebSockets. The default origin check
+// only accepts pages served by DockFormer itself.
+var execUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// execMessage is a message sent by the terminal. Input carries keystrokes
+// in Data, resize carries the new terminal size.
+type execMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols uint   `json:"cols,omitempty"`
+	Rows uint   `json:"rows,omitempty"`
+}
+
+// Web UI handlers
+func execPageHandler(c *gin.Context) {
+	id := c.Param("id")
+	var containerObj models.Container
+
+	if err := database.GetDB().First(&containerObj, id).Error; err != nil {
+		c.HTML(http.StatusNotFound, "error.html", gin.H{
+			"error": "Container not found",
+		})
+		return
+	}
+
+	c.HTML(http.StatusOK, "exec.html", gin.H{
+		"container": containerObj,
+		"shell":     execShell(""),
+	})
+}
+
+// API handlers
+func execContainer(c *gin.Context) {
+	id := c.Param("id")
+	var containerObj models.Container
+
+	if err := database.GetDB().First(&containerObj, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Container not found"})
+		return
+	}
+
+	command := strings.Fields(execShell(c.Query("shell")))
+	if len(command) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "shell must not be empty"})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	execResponse, err := dockerClient.ContainerExecCreate(ctx, containerObj.Name, container.ExecOptions{
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          []string{"TERM=xterm-256color"},
+		Cmd:          command,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create exec: " + err.Error()})
+		return
+	}
+
+	conn, err := execUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already wrote an error response
+		log.Printf("Failed to upgrade exec connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	hijacked, err := dockerClient.ContainerExecAttach(ctx, execResponse.ID, container.ExecAttachOptions{Tty: true})
+	if err != nil {
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "Failed to attach: "+err.Error()))
+		return
+	}
+	defer hijacked.Close()
+
+	session := models.ExecSession{
+		ContainerName: containerObj.Name,
+		ExecID:        execResponse.ID,
+		User:          requestActor(c),
+		Command:       strings.Join(command, " "),
+		StartedAt:     time.Now(),
+	}
+	if err := database.GetDB().Create(&session).Error; err != nil {
+		log.Printf("Failed to record exec session: %v", err)
+	}
+	defer finishExecSession(&session)
+
+	// Container output to the browser
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buffer := make([]byte, 32*1024)
+		for {
+			n, err := hijacked.Reader.Read(buffer)
+			if n > 0 {
+				if err := conn.WriteMessage(websocket.BinaryMessage, buffer[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Browser input to the container, until either side goes away
+	go func() {
+		defer cancel()
+		for {
+			var message execMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+
+			switch message.Type {
+			case "input":
+				if _, err := hijacked.Conn.Write([]byte(message.Data)); err != nil {
+					return
+				}
+			case "resize":
+				if message.Cols == 0 || message.Rows == 0 {
+					continue
+				}
+				err := dockerClient.ContainerExecResize(ctx, execResponse.ID, container.ResizeOptions{
+					Height: message.Rows,
+					Width:  message.Cols,
+				})
+				if err != nil {
+					log.Printf("Failed to resize exec %s: %v", execResponse.ID, err)
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-outputDone:
+		// The shell exited
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "exited"))
+	case <-ctx.Done():
+		// The browser disconnected, closing stdin ends the shell
+		hijacked.CloseWrite()
+	}
+}
+
+func getExecSessions(c *gin.Context) {
+	var sessions []models.ExecSession
+
+	query := database.GetDB().Order("started_at desc")
+	if name := c.Query("container"); name != "" {
+		query = query.Where("container_name = ?", name)
+	}
+
+	if err := query.Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// Helper functions
+
+// execShell returns the command to run for an exec session, preferring the
+// requested one over DOCKFORMER_EXEC_SHELL and the default
+func execShell(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if shell := os.Getenv("DOCKFORMER_EXEC_SHELL"); shell != "" {
+		return shell
+	}
+	return defaultExecShell
+}
+
+// finishExecSession records how long an exec session lasted and how its
+// command exited
+func finishExecSession(session *models.ExecSession) {
+	if session.ID == 0 {
+		return
+	}
+
+	endedAt := time.Now()
+	session.EndedAt = &endedAt
+	session.DurationMs = endedAt.Sub(session.StartedAt).Milliseconds()
+
+	// Give the shell a moment to exit after its stdin was closed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for ctx.Err() == nil {
+		info, err := dockerClient.ContainerExecInspect(ctx, session.ExecID)
+		if err != nil {
+			break
+		}
+		if !info.Running {
+			exitCode := info.ExitCode
+			session.ExitCode = &exitCode
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if err := database.GetDB().Save(session).Error; err != nil {
+		log.Printf("Failed to record end of exec session %d: %v", session.ID, err)
+	}
+}
@@ -0,0 +1,1237 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	timetypes "github.com/docker/docker/api/types/time"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	mathrand "math/rand/v2"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timing and limits of the fake engine
+const (
+	// fakeLogInterval is how often running containers log a line
+	fakeLogInterval = 5 * time.Second
+	// fakeHealthDelay is how long containers with a healthcheck stay
+	// starting before they turn healthy
+	fakeHealthDelay = 2 * time.Second
+	// fakeMaxLogLines is how many log lines each container keeps
+	fakeMaxLogLines = 10000
+	// fakeMemoryLimit is the memory reported for containers without a limit
+	fakeMemoryLimit = 2 << 30
+	// fakeOnlineCPUs is the number of CPUs reported in stats
+	fakeOnlineCPUs = 2
+)
+
+// fakeEngine is an Engine that runs nothing. Containers, images and
+// networks only exist in memory: containers go through the same states as
+// Docker's, write a few log lines while they run, report made-up stats and
+// answer exec sessions with a tiny shell. Selected with
+// DOCKFORMER_ENGINE=fake to demo the UI or exercise handlers without Docker.
+type fakeEngine struct {
+	mu          sync.Mutex
+	containers  map[string]*fakeContainer
+	images      map[string]time.Time
+	networks    map[string]*network.Inspect
+	execs       map[string]*fakeExec
+	subscribers map[*fakeSubscriber]bool
+	// pending holds the events to deliver once the lock is released
+	pending []events.Message
+}
+
+// fakeContainer is a container of the fake engine
+type fakeContainer struct {
+	id         string
+	name       string
+	created    time.Time
+	imageID    string
+	config     container.Config
+	hostConfig container.HostConfig
+	state      container.State
+	networks   map[string]*network.EndpointSettings
+	// generation changes on every start and stop, so delayed health
+	// changes of an earlier run are ignored
+	generation int
+
+	logs []fakeLogLine
+	// dropped counts the log lines discarded to stay under fakeMaxLogLines
+	dropped int
+	// changed is closed and replaced when logs are written or the
+	// container stops, waking log followers
+	changed chan struct{}
+}
+
+// fakeLogLine is a line written by a fake container
+type fakeLogLine struct {
+	time   time.Time
+	stream stdcopy.StdType
+	text   string
+}
+
+// fakeExec is an exec session of the fake engine
+type fakeExec struct {
+	id          string
+	containerID string
+	env         []string
+	running     bool
+	exitCode    int
+	// conn is the shell's end of the attached connection
+	conn net.Conn
+}
+
+// fakeSubscriber receives the events of one Events call
+type fakeSubscriber struct {
+	ctx        context.Context
+	containers bool
+	messages   chan events.Message
+}
+
+// newFakeEngine returns a fake engine with Docker's predefined networks
+func newFakeEngine() *fakeEngine {
+	e := &fakeEngine{
+		containers:  make(map[string]*fakeContainer),
+		images:      make(map[string]time.Time),
+		networks:    make(map[string]*network.Inspect),
+		execs:       make(map[string]*fakeExec),
+		subscribers: make(map[*fakeSubscriber]bool),
+	}
+	for _, name := range []string{"bridge", "host", "none"} {
+		id := fakeID()
+		e.networks[id] = &network.Inspect{
+			Name:       name,
+			ID:         id,
+			Created:    time.Now(),
+			Scope:      "local",
+			Driver:     name,
+			IPAM:       network.IPAM{Driver: "default"},
+			Containers: make(map[string]network.EndpointResource),
+		}
+	}
+
+	go e.writeLogs()
+	return e
+}
+
+// Containers
+
+func (e *fakeEngine) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	if config == nil || config.Image == "" {
+		return container.CreateResponse{}, errdefs.InvalidParameter(errors.New("no image specified"))
+	}
+	imageKey, err := fakeImageKey(config.Image)
+	if err != nil {
+		return container.CreateResponse{}, err
+	}
+
+	e.mu.Lock()
+	defer e.unlock()
+
+	if _, ok := e.images[imageKey]; !ok {
+		return container.CreateResponse{}, errdefs.NotFound(fmt.Errorf("No such image: %s", config.Image))
+	}
+
+	id := fakeID()
+	name := strings.TrimPrefix(containerName, "/")
+	if name == "" {
+		name = "fake_" + id[:12]
+	}
+	if existing, err := e.findContainer(name); err == nil {
+		return container.CreateResponse{}, errdefs.Conflict(fmt.Errorf("Conflict. The container name \"/%s\" is already in use by container \"%s\"", name, existing.id))
+	}
+
+	c := &fakeContainer{
+		id:       id,
+		name:     name,
+		created:  time.Now(),
+		imageID:  fakeDigest(imageKey),
+		config:   *config,
+		state:    container.State{Status: "created", StartedAt: time.Time{}.Format(time.RFC3339Nano), FinishedAt: time.Time{}.Format(time.RFC3339Nano)},
+		networks: make(map[string]*network.EndpointSettings),
+		changed:  make(chan struct{}),
+	}
+	if hostConfig != nil {
+		c.hostConfig = *hostConfig
+	}
+	if c.config.Hostname == "" {
+		c.config.Hostname = id[:12]
+	}
+
+	// Join the network mode's network and any other endpoint requested
+	endpoints := make(map[string]*network.EndpointSettings)
+	switch mode := string(c.hostConfig.NetworkMode); {
+	case mode == "" || mode == "default":
+		endpoints["bridge"] = nil
+	case !strings.HasPrefix(mode, "container:"):
+		endpoints[mode] = nil
+	}
+	if networkingConfig != nil {
+		for name, endpoint := range networkingConfig.EndpointsConfig {
+			endpoints[name] = endpoint
+		}
+	}
+	attach := make(map[*network.Inspect]*network.EndpointSettings)
+	for networkName, endpoint := range endpoints {
+		n, err := e.findNetwork(networkName)
+		if err != nil {
+			return container.CreateResponse{}, err
+		}
+		attach[n] = endpoint
+	}
+
+	e.containers[id] = c
+	for n, endpoint := range attach {
+		e.attach(c, n, endpoint)
+	}
+	e.emit(c, events.ActionCreate, nil)
+	return container.CreateResponse{ID: id}, nil
+}
+
+func (e *fakeEngine) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return container.InspectResponse{}, err
+	}
+
+	state := c.state
+	if c.state.Health != nil {
+		health := *c.state.Health
+		health.Log = append([]*container.HealthcheckResult{}, health.Log...)
+		state.Health = &health
+	}
+	hostConfig := c.hostConfig
+	config := c.config
+
+	path, args := "", []string(nil)
+	if len(c.config.Cmd) > 0 {
+		path, args = c.config.Cmd[0], c.config.Cmd[1:]
+	}
+
+	networks := make(map[string]*network.EndpointSettings, len(c.networks))
+	for name, endpoint := range c.networks {
+		endpointCopy := *endpoint
+		networks[name] = &endpointCopy
+	}
+
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:         c.id,
+			Created:    c.created.UTC().Format(time.RFC3339Nano),
+			Path:       path,
+			Args:       args,
+			State:      &state,
+			Image:      c.imageID,
+			Name:       "/" + c.name,
+			Driver:     "fake",
+			Platform:   "linux",
+			HostConfig: &hostConfig,
+		},
+		Config:          &config,
+		NetworkSettings: &container.NetworkSettings{Networks: networks},
+	}, nil
+}
+
+func (e *fakeEngine) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	var summaries []container.Summary
+	for _, c := range e.containers {
+		if !options.All && !c.state.Running {
+			continue
+		}
+		if !fakeLabelsMatch(options.Filters, c.config.Labels) {
+			continue
+		}
+		summaries = append(summaries, c.summary())
+	}
+
+	// Newest first, like Docker
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Created > summaries[j].Created
+	})
+	return summaries, nil
+}
+
+func (e *fakeEngine) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if !c.state.Running {
+		e.start(c)
+	}
+	return nil
+}
+
+func (e *fakeEngine) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if c.state.Running {
+		e.log(c, stdcopy.Stdout, "Received SIGTERM, shutting down")
+		e.stop(c, 0)
+		e.emit(c, events.ActionStop, nil)
+	}
+	return nil
+}
+
+func (e *fakeEngine) ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if c.state.Running {
+		e.log(c, stdcopy.Stdout, "Received SIGTERM, shutting down")
+		e.stop(c, 0)
+	}
+	e.start(c)
+	e.emit(c, events.ActionRestart, nil)
+	return nil
+}
+
+func (e *fakeEngine) ContainerRename(ctx context.Context, containerID, newContainerName string) error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	newName := strings.TrimPrefix(newContainerName, "/")
+	if existing, err := e.findContainer(newName); err == nil && existing != c {
+		return errdefs.Conflict(fmt.Errorf("Conflict. The container name \"/%s\" is already in use by container \"%s\"", newName, existing.id))
+	}
+
+	oldName := c.name
+	c.name = newName
+	for _, endpoint := range c.networks {
+		if n, ok := e.networks[endpoint.NetworkID]; ok {
+			n.Containers[c.id] = network.EndpointResource{Name: newName, EndpointID: endpoint.EndpointID}
+		}
+	}
+	e.emit(c, events.ActionRename, map[string]string{"oldName": "/" + oldName})
+	return nil
+}
+
+func (e *fakeEngine) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if c.state.Running {
+		if !options.Force {
+			return errdefs.Conflict(fmt.Errorf("cannot remove container \"/%s\": container is running: stop the container before removing or force remove", c.name))
+		}
+		e.emit(c, events.ActionKill, map[string]string{"signal": "9"})
+		e.stop(c, 137)
+	}
+
+	for _, endpoint := range c.networks {
+		if n, ok := e.networks[endpoint.NetworkID]; ok {
+			delete(n.Containers, c.id)
+		}
+	}
+	delete(e.containers, c.id)
+	c.notify()
+	e.emit(c, events.ActionDestroy, nil)
+	return nil
+}
+
+// ContainerLogs returns the logs of a container, multiplexed unless it has
+// a TTY. Following ends when the container stops or is removed.
+func (e *fakeEngine) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	now := time.Now()
+	since, err := fakeLogTime(options.Since, now)
+	if err != nil {
+		return nil, err
+	}
+	until, err := fakeLogTime(options.Until, now)
+	if err != nil {
+		return nil, err
+	}
+	tail := -1
+	if options.Tail != "" && options.Tail != "all" {
+		if tail, err = strconv.Atoi(options.Tail); err != nil || tail < 0 {
+			return nil, errdefs.InvalidParameter(fmt.Errorf("invalid value for \"tail\": %q", options.Tail))
+		}
+	}
+
+	// Only lines in the requested streams and time window are sent
+	keep := func(line fakeLogLine) bool {
+		switch {
+		case line.stream == stdcopy.Stdout && !options.ShowStdout,
+			line.stream == stdcopy.Stderr && !options.ShowStderr,
+			!since.IsZero() && line.time.Before(since),
+			!until.IsZero() && !line.time.Before(until):
+			return false
+		}
+		return true
+	}
+
+	e.mu.Lock()
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		e.unlock()
+		return nil, err
+	}
+	lines, next := c.logsSince(0)
+	e.unlock()
+
+	var backlog []fakeLogLine
+	for _, line := range lines {
+		if keep(line) {
+			backlog = append(backlog, line)
+		}
+	}
+	if tail >= 0 && len(backlog) > tail {
+		backlog = backlog[len(backlog)-tail:]
+	}
+
+	reader, writer := io.Pipe()
+	write := func(lines []fakeLogLine) error {
+		for _, line := range lines {
+			if !keep(line) {
+				continue
+			}
+			text := line.text + "\n"
+			if options.Timestamps {
+				text = line.time.UTC().Format(time.RFC3339Nano) + " " + text
+			}
+			var w io.Writer = writer
+			if !c.config.Tty {
+				w = stdcopy.NewStdWriter(writer, line.stream)
+			}
+			if _, err := io.WriteString(w, text); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	go func() {
+		if err := write(backlog); err != nil || !options.Follow {
+			writer.Close()
+			return
+		}
+		for {
+			e.mu.Lock()
+			removed := e.containers[c.id] != c
+			lines, next = c.logsSince(next)
+			running, changed := c.state.Running, c.changed
+			e.unlock()
+
+			if err := write(lines); err != nil {
+				return
+			}
+			if removed || !running {
+				writer.Close()
+				return
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				writer.CloseWithError(ctx.Err())
+				return
+			}
+		}
+	}()
+	return reader, nil
+}
+
+// ContainerStats reports made-up usage every second while the container
+// runs
+func (e *fakeEngine) ContainerStats(ctx context.Context, containerID string, stream bool) (container.StatsResponseReader, error) {
+	e.mu.Lock()
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		e.unlock()
+		return container.StatsResponseReader{}, err
+	}
+	memoryLimit := uint64(fakeMemoryLimit)
+	if c.hostConfig.Memory > 0 {
+		memoryLimit = uint64(c.hostConfig.Memory)
+	}
+	e.unlock()
+
+	reader, writer := io.Pipe()
+	go func() {
+		encoder := json.NewEncoder(writer)
+		var previous container.StatsResponse
+		for {
+			e.mu.Lock()
+			running := e.containers[c.id] == c && c.state.Running
+			e.unlock()
+			if !running {
+				writer.Close()
+				return
+			}
+
+			current := fakeStats(c, previous, memoryLimit)
+			if err := encoder.Encode(current); err != nil {
+				return
+			}
+			if !stream {
+				writer.Close()
+				return
+			}
+			previous = current
+
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				writer.CloseWithError(ctx.Err())
+				return
+			}
+		}
+	}()
+	return container.StatsResponseReader{Body: reader, OSType: "linux"}, nil
+}
+
+// Exec sessions
+
+func (e *fakeEngine) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return container.ExecCreateResponse{}, err
+	}
+	if !c.state.Running {
+		return container.ExecCreateResponse{}, errdefs.Conflict(fmt.Errorf("container %s is not running", c.id))
+	}
+
+	exec := &fakeExec{
+		id:          fakeID(),
+		containerID: c.id,
+		env:         append(append([]string{"HOSTNAME=" + c.config.Hostname}, c.config.Env...), options.Env...),
+	}
+	e.execs[exec.id] = exec
+	return container.ExecCreateResponse{ID: exec.id}, nil
+}
+
+// ContainerExecAttach connects to a shell that understands echo, env,
+// hostname, pwd, whoami and exit
+func (e *fakeEngine) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	exec, ok := e.execs[execID]
+	if !ok {
+		return types.HijackedResponse{}, errdefs.NotFound(fmt.Errorf("No such exec instance: %s", execID))
+	}
+	if exec.conn != nil {
+		return types.HijackedResponse{}, errdefs.Conflict(fmt.Errorf("exec %s has already been started", execID))
+	}
+
+	clientConn, shellConn := net.Pipe()
+	exec.conn, exec.running = shellConn, true
+	hostname := exec.containerID[:12]
+
+	go func() {
+		exitCode := fakeShell(shellConn, hostname, exec.env)
+		shellConn.Close()
+
+		e.mu.Lock()
+		if exec.running {
+			exec.running, exec.exitCode = false, exitCode
+		}
+		e.unlock()
+	}()
+	return types.NewHijackedResponse(clientConn, "application/vnd.docker.raw-stream"), nil
+}
+
+func (e *fakeEngine) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	exec, ok := e.execs[execID]
+	if !ok {
+		return container.ExecInspect{}, errdefs.NotFound(fmt.Errorf("No such exec instance: %s", execID))
+	}
+	return container.ExecInspect{
+		ExecID:      exec.id,
+		ContainerID: exec.containerID,
+		Running:     exec.running,
+		ExitCode:    exec.exitCode,
+	}, nil
+}
+
+func (e *fakeEngine) ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	if _, ok := e.execs[execID]; !ok {
+		return errdefs.NotFound(fmt.Errorf("No such exec instance: %s", execID))
+	}
+	return nil
+}
+
+// Images
+
+func (e *fakeEngine) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	imageKey, err := fakeImageKey(imageID)
+	if err != nil {
+		return image.InspectResponse{}, err
+	}
+
+	e.mu.Lock()
+	defer e.unlock()
+
+	pulled, ok := e.images[imageKey]
+	if !ok {
+		return image.InspectResponse{}, errdefs.NotFound(fmt.Errorf("No such image: %s", imageID))
+	}
+	named, _ := reference.ParseNormalizedNamed(imageKey)
+	return image.InspectResponse{
+		ID:           fakeDigest(imageKey),
+		RepoTags:     []string{reference.FamiliarString(named)},
+		Created:      pulled.UTC().Format(time.RFC3339Nano),
+		Os:           "linux",
+		Architecture: runtime.GOARCH,
+	}, nil
+}
+
+// ImagePull pulls any well-formed reference instantly
+func (e *fakeEngine) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error) {
+	imageKey, err := fakeImageKey(refStr)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.images[imageKey] = time.Now()
+	e.unlock()
+
+	// Report progress the way Docker does, as a stream of JSON messages
+	var progress bytes.Buffer
+	encoder := json.NewEncoder(&progress)
+	for _, status := range []string{
+		"Pulling from " + imageKey,
+		"Digest: " + fakeDigest(imageKey),
+		"Status: Downloaded newer image for " + imageKey,
+	} {
+		encoder.Encode(map[string]string{"status": status})
+	}
+	return io.NopCloser(&progress), nil
+}
+
+// Networks
+
+func (e *fakeEngine) NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	if _, err := e.findNetwork(name); err == nil {
+		return network.CreateResponse{}, errdefs.Conflict(fmt.Errorf("network with name %s already exists", name))
+	}
+
+	n := &network.Inspect{
+		Name:       name,
+		ID:         fakeID(),
+		Created:    time.Now(),
+		Scope:      "local",
+		Driver:     options.Driver,
+		IPAM:       network.IPAM{Driver: "default"},
+		Internal:   options.Internal,
+		Containers: make(map[string]network.EndpointResource),
+		Options:    options.Options,
+		Labels:     options.Labels,
+	}
+	if n.Driver == "" {
+		n.Driver = "bridge"
+	}
+	if options.IPAM != nil {
+		n.IPAM = *options.IPAM
+	}
+	e.networks[n.ID] = n
+	return network.CreateResponse{ID: n.ID}, nil
+}
+
+func (e *fakeEngine) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	n, err := e.findNetwork(networkID)
+	if err != nil {
+		return network.Inspect{}, err
+	}
+	return fakeNetworkCopy(n), nil
+}
+
+func (e *fakeEngine) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	e.mu.Lock()
+	defer e.unlock()
+
+	var summaries []network.Summary
+	for _, n := range e.networks {
+		if fakeLabelsMatch(options.Filters, n.Labels) {
+			summaries = append(summaries, fakeNetworkCopy(n))
+		}
+	}
+	return summaries, nil
+}
+
+func (e *fakeEngine) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	n, err := e.findNetwork(networkID)
+	if err != nil {
+		return err
+	}
+	c, err := e.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.networks[n.Name]; ok {
+		return errdefs.Forbidden(fmt.Errorf("endpoint with name %s already exists in network %s", c.name, n.Name))
+	}
+
+	e.attach(c, n, config)
+	return nil
+}
+
+func (e *fakeEngine) NetworkRemove(ctx context.Context, networkID string) error {
+	e.mu.Lock()
+	defer e.unlock()
+
+	n, err := e.findNetwork(networkID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case n.Name == "bridge" || n.Name == "host" || n.Name == "none":
+		return errdefs.Forbidden(fmt.Errorf("%s is a pre-defined network and cannot be removed", n.Name))
+	case len(n.Containers) > 0:
+		return errdefs.Forbidden(fmt.Errorf("error while removing network: network %s id %s has active endpoints", n.Name, n.ID))
+	}
+
+	delete(e.networks, n.ID)
+	return nil
+}
+
+// Events
+
+// Events streams container events until ctx is cancelled. The fake engine
+// produces no other kind of event.
+func (e *fakeEngine) Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error) {
+	subscriber := &fakeSubscriber{
+		ctx:        ctx,
+		containers: options.Filters.ExactMatch("type", string(events.ContainerEventType)),
+		messages:   make(chan events.Message),
+	}
+	errs := make(chan error, 1)
+
+	e.mu.Lock()
+	e.subscribers[subscriber] = true
+	e.unlock()
+
+	go func() {
+		<-ctx.Done()
+		e.mu.Lock()
+		delete(e.subscribers, subscriber)
+		e.unlock()
+		errs <- ctx.Err()
+	}()
+	return subscriber.messages, errs
+}
+
+// Helper functions
+
+// unlock releases the engine's lock and then delivers the events emitted
+// while it was held, so subscribers may call back into the engine
+func (e *fakeEngine) unlock() {
+	pending := e.pending
+	e.pending = nil
+	var subscribers []*fakeSubscriber
+	for subscriber := range e.subscribers {
+		if subscriber.containers {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, message := range pending {
+		for _, subscriber := range subscribers {
+			select {
+			case subscriber.messages <- message:
+			case <-subscriber.ctx.Done():
+			}
+		}
+	}
+}
+
+// emit queues a container event, carrying the container's labels like
+// Docker's
+func (e *fakeEngine) emit(c *fakeContainer, action events.Action, attributes map[string]string) {
+	actor := events.Actor{ID: c.id, Attributes: make(map[string]string)}
+	for key, value := range c.config.Labels {
+		actor.Attributes[key] = value
+	}
+	for key, value := range attributes {
+		actor.Attributes[key] = value
+	}
+	actor.Attributes["name"] = c.name
+	actor.Attributes["image"] = c.config.Image
+
+	now := time.Now()
+	e.pending = append(e.pending, events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    actor,
+		Scope:    "local",
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	})
+}
+
+// findContainer looks a container up by ID, name or unique ID prefix
+func (e *fakeEngine) findContainer(idOrName string) (*fakeContainer, error) {
+	if c, ok := e.containers[idOrName]; ok {
+		return c, nil
+	}
+	name := strings.TrimPrefix(idOrName, "/")
+	for _, c := range e.containers {
+		if c.name == name {
+			return c, nil
+		}
+	}
+
+	var found *fakeContainer
+	for id, c := range e.containers {
+		if idOrName != "" && strings.HasPrefix(id, idOrName) {
+			if found != nil {
+				return nil, errdefs.InvalidParameter(fmt.Errorf("multiple IDs found with provided prefix: %s", idOrName))
+			}
+			found = c
+		}
+	}
+	if found == nil {
+		return nil, errdefs.NotFound(fmt.Errorf("No such container: %s", idOrName))
+	}
+	return found, nil
+}
+
+// findNetwork looks a network up by ID, name or ID prefix
+func (e *fakeEngine) findNetwork(idOrName string) (*network.Inspect, error) {
+	if n, ok := e.networks[idOrName]; ok {
+		return n, nil
+	}
+	for _, n := range e.networks {
+		if n.Name == idOrName {
+			return n, nil
+		}
+	}
+	for id, n := range e.networks {
+		if idOrName != "" && strings.HasPrefix(id, idOrName) {
+			return n, nil
+		}
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("network %s not found", idOrName))
+}
+
+// attach joins a container to a network
+func (e *fakeEngine) attach(c *fakeContainer, n *network.Inspect, config *network.EndpointSettings) {
+	endpoint := &network.EndpointSettings{}
+	if config != nil {
+		*endpoint = *config
+	}
+	endpoint.NetworkID = n.ID
+	endpoint.EndpointID = fakeID()
+
+	c.networks[n.Name] = endpoint
+	n.Containers[c.id] = network.EndpointResource{Name: c.name, EndpointID: endpoint.EndpointID}
+}
+
+// start runs a container, turning it healthy after fakeHealthDelay if it
+// has a healthcheck
+func (e *fakeEngine) start(c *fakeContainer) {
+	c.generation++
+	c.state.Running = true
+	c.state.Status = "running"
+	c.state.Pid = 1000 + mathrand.IntN(30000)
+	c.state.ExitCode = 0
+	c.state.StartedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	c.state.Health = nil
+
+	if test := c.config.Healthcheck; test != nil && len(test.Test) > 0 && test.Test[0] != "NONE" {
+		c.state.Health = &container.Health{Status: container.Starting}
+		generation := c.generation
+		time.AfterFunc(fakeHealthDelay, func() {
+			e.mu.Lock()
+			defer e.unlock()
+			if e.containers[c.id] != c || c.generation != generation {
+				return
+			}
+			now := time.Now()
+			c.state.Health.Status = container.Healthy
+			c.state.Health.Log = append(c.state.Health.Log, &container.HealthcheckResult{
+				Start:  now,
+				End:    now,
+				Output: "healthy (fake engine)",
+			})
+			e.emit(c, events.Action(string(events.ActionHealthStatus)+": "+container.Healthy), nil)
+		})
+	}
+
+	e.log(c, stdcopy.Stdout, fmt.Sprintf("Starting %s as %s", c.config.Image, c.name))
+	ports := make([]string, 0, len(c.config.ExposedPorts))
+	for port := range c.config.ExposedPorts {
+		ports = append(ports, string(port))
+	}
+	sort.Strings(ports)
+	for _, port := range ports {
+		e.log(c, stdcopy.Stdout, "Listening on "+port)
+	}
+	e.emit(c, events.ActionStart, nil)
+}
+
+// stop ends a running container with exitCode and the exec sessions in it
+func (e *fakeEngine) stop(c *fakeContainer, exitCode int) {
+	c.generation++
+	c.state.Running = false
+	c.state.Status = "exited"
+	c.state.Pid = 0
+	c.state.ExitCode = exitCode
+	c.state.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+
+	for _, exec := range e.execs {
+		if exec.containerID == c.id && exec.running {
+			exec.running, exec.exitCode = false, 137
+			exec.conn.Close()
+		}
+	}
+
+	c.notify()
+	e.emit(c, events.ActionDie, map[string]string{"exitCode": strconv.Itoa(exitCode)})
+}
+
+// log writes a line to a container's logs
+func (e *fakeEngine) log(c *fakeContainer, stream stdcopy.StdType, text string) {
+	c.logs = append(c.logs, fakeLogLine{time: time.Now(), stream: stream, text: text})
+	if extra := len(c.logs) - fakeMaxLogLines; extra > 0 {
+		c.logs = append([]fakeLogLine{}, c.logs[extra:]...)
+		c.dropped += extra
+	}
+	c.notify()
+}
+
+// writeLogs has every running container log a line every fakeLogInterval,
+// for as long as the process runs
+func (e *fakeEngine) writeLogs() {
+	ticker := time.NewTicker(fakeLogInterval)
+	defer ticker.Stop()
+
+	for tick := 1; ; tick++ {
+		<-ticker.C
+		e.mu.Lock()
+		for _, c := range e.containers {
+			if !c.state.Running {
+				continue
+			}
+			if tick%6 == 0 {
+				e.log(c, stdcopy.Stderr, "warning: running on the fake engine, nothing is actually executed")
+				continue
+			}
+			e.log(c, stdcopy.Stdout, fmt.Sprintf("Handled %d requests in the last %s", mathrand.IntN(100), fakeLogInterval))
+		}
+		e.unlock()
+	}
+}
+
+// logsSince returns the log lines from position next on and the position
+// after them. Positions count every line ever written.
+func (c *fakeContainer) logsSince(next int) ([]fakeLogLine, int) {
+	start := max(next-c.dropped, 0)
+	return append([]fakeLogLine{}, c.logs[start:]...), c.dropped + len(c.logs)
+}
+
+// notify wakes the log followers of a container
+func (c *fakeContainer) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// summary describes a container as listed by Docker
+func (c *fakeContainer) summary() container.Summary {
+	var status string
+	switch {
+	case c.state.Running:
+		started, _ := time.Parse(time.RFC3339Nano, c.state.StartedAt)
+		status = "Up " + units.HumanDuration(time.Since(started))
+	case c.state.Status == "exited":
+		finished, _ := time.Parse(time.RFC3339Nano, c.state.FinishedAt)
+		status = fmt.Sprintf("Exited (%d) %s ago", c.state.ExitCode, units.HumanDuration(time.Since(finished)))
+	default:
+		status = "Created"
+	}
+
+	var ports []container.Port
+	for port, bindings := range c.hostConfig.PortBindings {
+		for _, binding := range bindings {
+			publicPort, _ := strconv.ParseUint(binding.HostPort, 10, 16)
+			ports = append(ports, container.Port{
+				IP:          "0.0.0.0",
+				PrivatePort: uint16(port.Int()),
+				PublicPort:  uint16(publicPort),
+				Type:        port.Proto(),
+			})
+		}
+	}
+
+	return container.Summary{
+		ID:      c.id,
+		Names:   []string{"/" + c.name},
+		Image:   c.config.Image,
+		ImageID: c.imageID,
+		Command: strings.Join(c.config.Cmd, " "),
+		Created: c.created.Unix(),
+		Ports:   ports,
+		Labels:  c.config.Labels,
+		State:   c.state.Status,
+		Status:  status,
+	}
+}
+
+// fakeNetworkCopy returns a copy of a network whose endpoints can be read
+// without the lock
+func fakeNetworkCopy(n *network.Inspect) network.Inspect {
+	networkCopy := *n
+	networkCopy.Containers = make(map[string]network.EndpointResource, len(n.Containers))
+	for id, endpoint := range n.Containers {
+		networkCopy.Containers[id] = endpoint
+	}
+	return networkCopy
+}
+
+// fakeStats returns the next made-up stats sample of a container
+func fakeStats(c *fakeContainer, previous container.StatsResponse, memoryLimit uint64) container.StatsResponse {
+	current := container.StatsResponse{
+		Name:        "/" + c.name,
+		ID:          c.id,
+		Read:        time.Now(),
+		PreRead:     previous.Read,
+		PreCPUStats: previous.CPUStats,
+		PidsStats:   container.PidsStats{Current: uint64(1 + mathrand.IntN(8))},
+		MemoryStats: container.MemoryStats{
+			Usage: min(uint64(48<<20+mathrand.IntN(32<<20)), memoryLimit),
+			Limit: memoryLimit,
+		},
+	}
+
+	// Between 1% and 30% of one CPU over the last second
+	current.CPUStats = previous.CPUStats
+	current.CPUStats.OnlineCPUs = fakeOnlineCPUs
+	current.CPUStats.SystemUsage += fakeOnlineCPUs * uint64(time.Second)
+	current.CPUStats.CPUUsage.TotalUsage += uint64((0.01 + 0.29*mathrand.Float64()) * float64(time.Second))
+
+	rx, tx := uint64(0), uint64(0)
+	if eth0, ok := previous.Networks["eth0"]; ok {
+		rx, tx = eth0.RxBytes, eth0.TxBytes
+	}
+	current.Networks = map[string]container.NetworkStats{
+		"eth0": {RxBytes: rx + uint64(mathrand.IntN(64<<10)), TxBytes: tx + uint64(mathrand.IntN(32<<10))},
+	}
+
+	read, write := blockBytes(&previous)
+	current.BlkioStats.IoServiceBytesRecursive = []container.BlkioStatEntry{
+		{Op: "read", Value: read + uint64(mathrand.IntN(16<<10))},
+		{Op: "write", Value: write + uint64(mathrand.IntN(8<<10))},
+	}
+	return current
+}
+
+// fakeShell runs a minimal interactive shell on conn and returns its exit
+// code. It echoes input itself, like a terminal in raw mode expects.
+func fakeShell(conn net.Conn, hostname string, env []string) int {
+	const prompt = "/ # "
+	_, err := io.WriteString(conn, "DockFormer fake engine: nothing really runs here. "+
+		"Try echo, env, hostname, pwd, whoami or exit.\r\n"+prompt)
+	if err != nil {
+		return 129
+	}
+
+	var line []byte
+	status, escape := 0, false
+	buffer := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			// Hung up, as a shell killed by SIGHUP
+			return 129
+		}
+
+		var out bytes.Buffer
+		for _, b := range buffer[:n] {
+			// Skip escape sequences such as arrow keys
+			if escape {
+				escape = b == '[' || b < 0x40 || b > 0x7e
+				continue
+			}
+
+			switch {
+			case b == 0x1b:
+				escape = true
+			case b == '\r' || b == '\n':
+				out.WriteString("\r\n")
+				var output string
+				var exit bool
+				output, status, exit = fakeCommand(string(line), hostname, env, status)
+				out.WriteString(output)
+				if exit {
+					conn.Write(out.Bytes())
+					return status
+				}
+				line = line[:0]
+				out.WriteString(prompt)
+			case b == 0x7f || b == '\b':
+				if len(line) > 0 {
+					line = line[:len(line)-1]
+					out.WriteString("\b \b")
+				}
+			case b == 0x03:
+				line = line[:0]
+				status = 130
+				out.WriteString("^C\r\n" + prompt)
+			case b == 0x04 && len(line) == 0:
+				out.WriteString("exit\r\n")
+				conn.Write(out.Bytes())
+				return status
+			case b >= 0x20:
+				line = append(line, b)
+				out.WriteByte(b)
+			}
+		}
+		if _, err := conn.Write(out.Bytes()); err != nil {
+			return 129
+		}
+	}
+}
+
+// fakeCommand runs a command line of the fake shell, returning its output,
+// exit status and whether the shell should exit
+func fakeCommand(line string, hostname string, env []string, status int) (string, int, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", status, false
+	}
+
+	switch fields[0] {
+	case "exit":
+		if len(fields) > 1 {
+			code, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return fmt.Sprintf("sh: exit: Illegal number: %s\r\n", fields[1]), 2, false
+			}
+			return "", code & 0xff, true
+		}
+		return "", status, true
+	case "echo":
+		return strings.Join(fields[1:], " ") + "\r\n", 0, false
+	case "env":
+		return strings.Join(env, "\r\n") + "\r\n", 0, false
+	case "hostname":
+		return hostname + "\r\n", 0, false
+	case "pwd":
+		return "/\r\n", 0, false
+	case "whoami":
+		return "root\r\n", 0, false
+	default:
+		return fmt.Sprintf("sh: %s: not found\r\n", fields[0]), 127, false
+	}
+}
+
+// fakeLabelsMatch reports whether labels satisfy the "label" filters, each
+// "key" or "key=value"
+func fakeLabelsMatch(args filters.Args, labels map[string]string) bool {
+	for _, filter := range args.Get("label") {
+		key, value, hasValue := strings.Cut(filter, "=")
+		actual, ok := labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeLogTime parses the since and until log options the way Docker does:
+// a Unix timestamp, an RFC 3339 time or a duration before now
+func fakeLogTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	timestamp, err := timetypes.GetTimestamp(value, now)
+	if err != nil {
+		return time.Time{}, errdefs.InvalidParameter(err)
+	}
+	seconds, nanoseconds, err := timetypes.ParseTimestamps(timestamp, 0)
+	if err != nil {
+		return time.Time{}, errdefs.InvalidParameter(err)
+	}
+	return time.Unix(seconds, nanoseconds), nil
+}
+
+// fakeImageKey normalizes an image reference, so nginx and
+// docker.io/library/nginx:latest are the same image
+func fakeImageKey(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errdefs.InvalidParameter(fmt.Errorf("invalid reference format: %w", err))
+	}
+	return reference.TagNameOnly(named).String(), nil
+}
+
+// fakeDigest returns a stable image ID for a name
+func fakeDigest(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fakeID returns a random 64 character ID like Docker's
+func fakeID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"testing"
+	"time"
+)
+
+// fakeSubscription subscribes to the container events of an engine for the
+// rest of a test
+func fakeSubscription(t *testing.T, engine *fakeEngine) <-chan events.Message {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	messages, _ := engine.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("type", string(events.ContainerEventType))),
+	})
+	return messages
+}
+
+// fakeRun pulls the image of a container, then creates and starts it. With
+// a subscriber it blocks until the events are taken, so it is run in a
+// goroutine.
+func fakeRun(engine *fakeEngine, name string, config *container.Config) error {
+	ctx := context.Background()
+	progress, err := engine.ImagePull(ctx, config.Image, image.PullOptions{})
+	if err != nil {
+		return err
+	}
+	progress.Close()
+	if _, err := engine.ContainerCreate(ctx, config, &container.HostConfig{}, nil, nil, name); err != nil {
+		return err
+	}
+	return engine.ContainerStart(ctx, name, container.StartOptions{})
+}
+
+// nextEvent waits for the next event of a subscription
+func nextEvent(t *testing.T, messages <-chan events.Message) events.Message {
+	t.Helper()
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return events.Message{}
+	}
+}
+
+// expectEvents takes the next events of a subscription, failing the test
+// unless they have the given actions
+func expectEvents(t *testing.T, messages <-chan events.Message, actions ...events.Action) {
+	t.Helper()
+	for _, action := range actions {
+		if message := nextEvent(t, messages); message.Action != action {
+			t.Fatalf("expected a %s event, got %s", action, message.Action)
+		}
+	}
+}
+
+// wait waits for a call run in a goroutine to return
+func wait(t *testing.T, done <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to %s: %v", what, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting to %s", what)
+	}
+}
+
+func TestFakeEngineDeliversEventsBeforeReturning(t *testing.T) {
+	engine := newFakeEngine()
+	if err := fakeRun(engine, "web", &container.Config{Image: "nginx:1.27"}); err != nil {
+		t.Fatalf("failed to run web: %v", err)
+	}
+	messages := fakeSubscription(t, engine)
+
+	// The stop only returns once every subscriber has taken its events, so
+	// handlers reacting to events see them in the order of the calls
+	done := make(chan error, 1)
+	go func() { done <- engine.ContainerStop(context.Background(), "web", container.StopOptions{}) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("stop returned before its events were delivered: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	message := nextEvent(t, messages)
+	if message.Action != events.ActionDie || message.Actor.Attributes["name"] != "web" || message.Actor.Attributes["image"] != "nginx:1.27" {
+		t.Errorf("expected a die event of web, got %s with %v", message.Action, message.Actor.Attributes)
+	}
+	expectEvents(t, messages, events.ActionStop)
+	wait(t, done, "stop web")
+}
+
+func TestFakeEngineHealthTransitions(t *testing.T) {
+	engine := newFakeEngine()
+	messages := fakeSubscription(t, engine)
+
+	ctx := context.Background()
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- fakeRun(engine, "db", &container.Config{
+			Image:       "postgres:16",
+			Healthcheck: &container.HealthConfig{Test: []string{"CMD-SHELL", "pg_isready"}},
+		})
+	}()
+	expectEvents(t, messages, events.ActionCreate, events.ActionStart)
+	wait(t, done, "run db")
+
+	info, err := engine.ContainerInspect(ctx, "db")
+	if err != nil {
+		t.Fatalf("failed to inspect db: %v", err)
+	}
+	if info.State.Health == nil || info.State.Health.Status != container.Starting {
+		t.Fatalf("expected db to start in the starting health state, got %+v", info.State.Health)
+	}
+
+	expectEvents(t, messages, events.Action(string(events.ActionHealthStatus)+": "+container.Healthy))
+	if elapsed := time.Since(started); elapsed < fakeHealthDelay {
+		t.Errorf("db turned healthy after %s, before fakeHealthDelay", elapsed)
+	}
+	info, err = engine.ContainerInspect(ctx, "db")
+	if err != nil {
+		t.Fatalf("failed to inspect db: %v", err)
+	}
+	if info.State.Health.Status != container.Healthy {
+		t.Errorf("expected db to be healthy, got %s", info.State.Health.Status)
+	}
+
+	// Containers without a healthcheck report no health at all
+	go func() { done <- fakeRun(engine, "web", &container.Config{Image: "nginx:1.27"}) }()
+	expectEvents(t, messages, events.ActionCreate, events.ActionStart)
+	wait(t, done, "run web")
+	if info, err := engine.ContainerInspect(ctx, "web"); err != nil || info.State.Health != nil {
+		t.Errorf("expected web to have no health status, got %+v (%v)", info.State.Health, err)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"time"
+)
+
+// HealthcheckConfig represents the YAML healthcheck of a container.
+// Durations use Go syntax, e.g. "30s" or "1m30s".
+type HealthcheckConfig struct {
+	Test        HealthcheckTest `yaml:"test"`
+	Interval    string          `yaml:"interval,omitempty"`
+	Timeout     string          `yaml:"timeout,omitempty"`
+	Retries     int             `yaml:"retries,omitempty"`
+	StartPeriod string          `yaml:"start_period,omitempty"`
+}
+
+// HealthcheckTest is the probe command. A plain string is run through the
+// container's shell, a list is either exec'd directly or starts with one of
+// Docker's CMD, CMD-SHELL or NONE keywords.
+type HealthcheckTest []string
+
+// UnmarshalYAML accepts both the string and the list form of a test
+func (t *HealthcheckTest) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var command string
+		if err := value.Decode(&command); err != nil {
+			return err
+		}
+		*t = HealthcheckTest{"CMD-SHELL", command}
+		return nil
+	}
+
+	var test []string
+	if err := value.Decode(&test); err != nil {
+		return err
+	}
+	if len(test) > 0 {
+		switch test[0] {
+		case "CMD", "CMD-SHELL", "NONE":
+		default:
+			test = append([]string{"CMD"}, test...)
+		}
+	}
+	*t = test
+	return nil
+}
+
+// Helper functions
+
+// toDocker converts the healthcheck into Docker's representation
+func (h *HealthcheckConfig) toDocker() (*container.HealthConfig, error) {
+	if len(h.Test) == 0 {
+		return nil, errors.New("healthcheck test is required")
+	}
+	if h.Retries < 0 {
+		return nil, errors.New("healthcheck retries must not be negative")
+	}
+
+	healthConfig := &container.HealthConfig{
+		Test:    h.Test,
+		Retries: h.Retries,
+	}
+
+	durations := []struct {
+		field  string
+		value  string
+		target *time.Duration
+	}{
+		{"interval", h.Interval, &healthConfig.Interval},
+		{"timeout", h.Timeout, &healthConfig.Timeout},
+		{"start_period", h.StartPeriod, &healthConfig.StartPeriod},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid healthcheck %s '%s': %w", d.field, d.value, err)
+		}
+		*d.target = duration
+	}
+
+	return healthConfig, nil
+}
+
+// healthFromState extracts the health status and the output of the most
+// recent probe from a container's state
+func healthFromState(state *container.State) (models.HealthStatus, string) {
+	if state == nil || state.Health == nil {
+		return models.HealthNone, ""
+	}
+
+	output := ""
+	if n := len(state.Health.Log); n > 0 {
+		output = strings.TrimSpace(state.Health.Log[n-1].Output)
+	}
+	return models.HealthStatus(state.Health.Status), output
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testServer is a router backed by a fresh database and the fake engine
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+	engine *fakeEngine
+}
+
+// newTestServer sets up a server with an empty SQLite database in place of
+// PostgreSQL and a fake engine in place of Docker
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	// WAL lets requests read while a deploy transaction is writing
+	dsn := filepath.Join(t.TempDir(), "dockformer.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := database.MigrateDB(db); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	previousDB, previousEngine := database.DB, dockerClient
+	engine := newFakeEngine()
+	database.DB, dockerClient = db, engine
+	t.Cleanup(func() { database.DB, dockerClient = previousDB, previousEngine })
+
+	// Background starts of stacks use the database and engine, so they must
+	// end before both are restored
+	t.Cleanup(func() {
+		deadline := time.Now().Add(dependencyTimeout)
+		for stackActionsRunning() && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	router := gin.New()
+	setupRoutes(router)
+	return &testServer{t: t, router: router, engine: engine}
+}
+
+// user creates a user with an API token and returns the token
+func (s *testServer) user(username string, role models.Role) string {
+	s.t.Helper()
+	user := models.User{Username: username, PasswordHash: "-", Role: role}
+	if err := database.GetDB().Create(&user).Error; err != nil {
+		s.t.Fatalf("failed to create user %s: %v", username, err)
+	}
+
+	token := tokenPrefix + username
+	apiToken := models.APIToken{Name: "test", UserID: user.ID, TokenHash: hashToken(token)}
+	if err := database.GetDB().Create(&apiToken).Error; err != nil {
+		s.t.Fatalf("failed to create token for %s: %v", username, err)
+	}
+	return token
+}
+
+// grant gives a user a role on a stack
+func (s *testServer) grant(username string, stackName string, role models.Role) {
+	s.t.Helper()
+	var user models.User
+	if err := database.GetDB().Where("username = ?", username).First(&user).Error; err != nil {
+		s.t.Fatalf("failed to load user %s: %v", username, err)
+	}
+	grant := models.StackGrant{UserID: user.ID, StackName: stackName, Role: role}
+	if err := database.GetDB().Create(&grant).Error; err != nil {
+		s.t.Fatalf("failed to grant %s on %s: %v", role, stackName, err)
+	}
+}
+
+// do sends a request with a token, encoding body as JSON unless it is
+// already a string
+func (s *testServer) do(token string, method string, target string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("failed to encode request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request := httptest.NewRequest(method, target, reader)
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// apply applies a stack as the given user, failing the test unless it
+// succeeds
+func (s *testServer) apply(token string, yamlData string) {
+	s.t.Helper()
+	if response := s.do(token, http.MethodPost, "/api/apply", yamlData); response.Code != http.StatusOK {
+		s.t.Fatalf("apply returned %d: %s", response.Code, response.Body.String())
+	}
+}
+
+// container loads a container row by name
+func (s *testServer) container(name string) models.Container {
+	s.t.Helper()
+	var containerObj models.Container
+	if err := database.GetDB().Where("name = ?", name).First(&containerObj).Error; err != nil {
+		s.t.Fatalf("failed to load container %s: %v", name, err)
+	}
+	return containerObj
+}
+
+// decode decodes a JSON response into value
+func decode(t *testing.T, response *httptest.ResponseRecorder, value interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(response.Body.Bytes(), value); err != nil {
+		t.Fatalf("failed to decode response %q: %v", response.Body.String(), err)
+	}
+}
+
+// stackActionsRunning reports whether any stack is being started in the
+// background
+func stackActionsRunning() bool {
+	stackActions.Lock()
+	defer stackActions.Unlock()
+	return len(stackActions.running) > 0
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logKeepAlive is how often an idle log stream sends a ping so proxies
+// don't close the connection
+const logKeepAlive = 15 * time.Second
+
+// maxLogLine bounds the length of a single log line
+const maxLogLine = 1024 * 1024
+
+// Output streams a log record can come from
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// LogRecord is a single line of container output. The timestamp is left
+// out when the request turned timestamps off.
+type LogRecord struct {
+	Stream    string    `json:"stream"`
+	Timestamp time.Time `json:"timestamp,omitzero"`
+	Text      string    `json:"text"`
+}
+
+// API handlers
+func getContainerLogs(c *gin.Context) {
+	containerObj, options, ok := logsRequest(c)
+	if !ok {
+		return
+	}
+	options.Until = c.Query("until")
+
+	ctx := c.Request.Context()
+	logReader, tty, err := openContainerLogs(ctx, containerObj.Name, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get container logs: " + err.Error()})
+		return
+	}
+	defer closeLogReader(logReader)
+
+	stream := c.Query("stream")
+	records := []LogRecord{}
+	for record := range logRecords(ctx, logReader, tty, options.Timestamps) {
+		if stream == "" || record.Stream == stream {
+			records = append(records, record)
+		}
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+func streamContainerLogs(c *gin.Context) {
+	containerObj, options, ok := logsRequest(c)
+	if !ok {
+		return
+	}
+	options.Follow = true
+
+	// The request context is cancelled when the client disconnects, which
+	// closes the Docker log stream
+	ctx := c.Request.Context()
+	logReader, tty, err := openContainerLogs(ctx, containerObj.Name, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get container logs: " + err.Error()})
+		return
+	}
+	defer closeLogReader(logReader)
+
+	// The server's write timeout would otherwise end the stream
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for log stream: %v", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	stream := c.Query("stream")
+	records := logRecords(ctx, logReader, tty, options.Timestamps)
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				// The container stopped, let the client know the stream ended
+				c.SSEvent("end", "")
+				return false
+			}
+			if stream == "" || record.Stream == stream {
+				c.SSEvent("log", record)
+			}
+			return true
+		case <-time.After(logKeepAlive):
+			c.SSEvent("ping", "")
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// Helper functions
+
+// logsRequest loads the container of a logs request and builds the log
+// options from the query, writing an error response when they are invalid
+func logsRequest(c *gin.Context) (*models.Container, container.LogsOptions, bool) {
+	id := c.Param("id")
+	var containerObj models.Container
+
+	if err := database.GetDB().First(&containerObj, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Container not found"})
+		return nil, container.LogsOptions{}, false
+	}
+
+	tail := c.DefaultQuery("tail", "100")
+	if tail != "all" {
+		if n, err := strconv.Atoi(tail); err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tail must be a non-negative number or 'all'"})
+			return nil, container.LogsOptions{}, false
+		}
+	}
+
+	timestamps, err := strconv.ParseBool(c.DefaultQuery("timestamps", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "timestamps must be 'true' or 'false'"})
+		return nil, container.LogsOptions{}, false
+	}
+
+	switch c.Query("stream") {
+	case "", StreamStdout, StreamStderr:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stream must be 'stdout' or 'stderr'"})
+		return nil, container.LogsOptions{}, false
+	}
+
+	return &containerObj, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       tail,
+		Since:      c.Query("since"),
+		Timestamps: timestamps,
+	}, true
+}
+
+// openContainerLogs opens the log stream of a container and reports whether
+// the container has a TTY, in which case the stream is not multiplexed
+func openContainerLogs(ctx context.Context, name string, options container.LogsOptions) (io.ReadCloser, bool, error) {
+	info, err := dockerClient.ContainerInspect(ctx, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	logReader, err := dockerClient.ContainerLogs(ctx, name, options)
+	if err != nil {
+		return nil, false, err
+	}
+	return logReader, info.Config != nil && info.Config.Tty, nil
+}
+
+// closeLogReader closes a Docker log stream, logging failures
+func closeLogReader(logReader io.ReadCloser) {
+	if err := logReader.Close(); err != nil {
+		log.Printf("Failed to close log reader: %v", err)
+	}
+}
+
+// logRecords decodes a Docker log stream into records sent on the returned
+// channel, which is closed when the stream ends. Streams of TTY containers
+// are raw output, the others are multiplexed with an 8-byte header per frame.
+// timestamps tells whether Docker prefixed every line with its timestamp.
+func logRecords(ctx context.Context, logReader io.Reader, tty bool, timestamps bool) <-chan LogRecord {
+	records := make(chan LogRecord)
+	send := func(stream string, line string) bool {
+		record := LogRecord{Stream: stream, Text: line}
+		if timestamps {
+			record = parseLogLine(stream, line)
+		}
+		select {
+		case records <- record:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(records)
+
+		var err error
+		if tty {
+			err = scanLines(logReader, func(line string) bool {
+				return send(StreamStdout, line)
+			})
+		} else {
+			err = demuxLogs(logReader, send)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to read container logs: %v", err)
+		}
+	}()
+	return records
+}
+
+// scanLines calls fn for every line read from r until fn returns false
+func scanLines(r io.Reader, fn func(line string) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLine)
+	for scanner.Scan() {
+		if !fn(strings.TrimSuffix(scanner.Text(), "\r")) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// demuxLogs splits a multiplexed Docker log stream into lines per stream.
+// A frame may hold several lines or part of one, so partial lines are kept
+// per stream until their newline arrives.
+func demuxLogs(r io.Reader, fn func(stream string, line string) bool) error {
+	header := make([]byte, 8)
+	partial := map[string]*bytes.Buffer{
+		StreamStdout: {},
+		StreamStderr: {},
+	}
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+
+		var stream string
+		switch header[0] {
+		case byte(stdcopy.Stdout), byte(stdcopy.Stdin):
+			stream = StreamStdout
+		case byte(stdcopy.Stderr):
+			stream = StreamStderr
+		case byte(stdcopy.Systemerr):
+			payload, _ := io.ReadAll(io.LimitReader(r, int64(binary.BigEndian.Uint32(header[4:]))))
+			return fmt.Errorf("docker: %s", payload)
+		default:
+			return fmt.Errorf("invalid log stream id %d", header[0])
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		buffer := partial[stream]
+		if _, err := io.CopyN(buffer, r, size); err != nil {
+			return err
+		}
+
+		for {
+			line, err := buffer.ReadString('\n')
+			if err != nil {
+				// Keep the incomplete line for the next frame
+				rest := []byte(line)
+				buffer.Reset()
+				buffer.Write(rest)
+				break
+			}
+			if !fn(stream, strings.TrimSuffix(line, "\n")) {
+				return nil
+			}
+		}
+		if buffer.Len() > maxLogLine {
+			if !fn(stream, buffer.String()) {
+				return nil
+			}
+			buffer.Reset()
+		}
+	}
+
+	// Flush lines that never got a newline
+	for _, stream := range []string{StreamStdout, StreamStderr} {
+		if buffer := partial[stream]; buffer.Len() > 0 {
+			if !fn(stream, buffer.String()) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// parseLogLine splits the timestamp Docker prefixes to every line from the
+// text of the line
+func parseLogLine(stream string, line string) LogRecord {
+	record := LogRecord{Stream: stream, Text: line}
+	prefix, text, found := strings.Cut(line, " ")
+	if !found {
+		prefix, text = line, ""
+	}
+	if timestamp, err := time.Parse(time.RFC3339Nano, prefix); err == nil {
+		record.Timestamp = timestamp
+		record.Text = text
+	}
+	return record
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics DockFormer records about itself
+var (
+	httpRequests = newCounterVec("dockformer_http_requests_total",
+		"HTTP requests handled, by route and status code.", "method", "route", "status")
+	httpDuration = newHistogramVec("dockformer_http_request_duration_seconds",
+		"Time to handle HTTP requests, by route.", "method", "route")
+	dockerRequests = newCounterVec("dockformer_docker_requests_total",
+		"Docker API requests made, by endpoint.", "method", "endpoint")
+	dockerErrors = newCounterVec("dockformer_docker_request_errors_total",
+		"Docker API requests that failed or returned an error status, by endpoint.", "method", "endpoint")
+	dockerDuration = newHistogramVec("dockformer_docker_request_duration_seconds",
+		"Time until the Docker API responded, by endpoint. Streams are timed until their headers arrive.", "method", "endpoint")
+	applyDuration = newHistogramVec("dockformer_apply_duration_seconds",
+		"Time to apply a stack from an upload, the API or a rollback, by result.", "result")
+)
+
+// counterVec is a counter with a set of label values per series
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// histogramVec is a histogram with a set of label values per series
+type histogramVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram counts observations per bucket
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// gaugeSample is one series of a gauge computed at scrape time
+type gaugeSample struct {
+	labels []string
+	values []string
+	value  float64
+}
+
+// API handlers
+func metricsHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := bufio.NewWriter(c.Writer)
+	defer w.Flush()
+
+	httpRequests.write(w)
+	httpDuration.write(w)
+	dockerRequests.write(w)
+	dockerErrors.write(w)
+	dockerDuration.write(w)
+	applyDuration.write(w)
+	writeDatabaseMetrics(w)
+	writeContainerMetrics(w)
+}
+
+// Helper functions
+
+// metricsMiddleware counts and times every request by its route pattern, so
+// requests for different containers share a series
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.inc(c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+		httpDuration.observe(time.Since(start).Seconds(), c.Request.Method, route)
+	}
+}
+
+// newCounterVec creates a counter with the given label names
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// inc increments the series with the given label values
+func (v *counterVec) inc(values ...string) {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	v.values[key]++
+	v.mu.Unlock()
+}
+
+// write renders the counter in the Prometheus text format
+func (v *counterVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", v.name, v.help, v.name)
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, strings.Split(key, "\xff")), formatValue(v.values[key]))
+	}
+}
+
+// newHistogramVec creates a latency histogram with the given label names
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, series: make(map[string]*histogram)}
+}
+
+// observe records a value in the series with the given label values
+func (v *histogramVec) observe(value float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	h, ok := v.series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		v.series[key] = h
+	}
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// write renders the histogram in the Prometheus text format
+func (v *histogramVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", v.name, v.help, v.name)
+	for _, key := range sortedKeys(v.series) {
+		h := v.series[key]
+		values := strings.Split(key, "\xff")
+		bucketLabels := append(append([]string{}, v.labels...), "le")
+		for i, bound := range latencyBuckets {
+			bucketValues := append(append([]string{}, values...), formatValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(bucketLabels, bucketValues), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(bucketLabels, append(append([]string{}, values...), "+Inf")), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatLabels(v.labels, values), formatValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatLabels(v.labels, values), h.count)
+	}
+}
+
+// writeGauge renders a gauge computed at scrape time
+func writeGauge(w *bufio.Writer, name, help string, samples []gaugeSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(sample.labels, sample.values), formatValue(sample.value))
+	}
+}
+
+// writeDatabaseMetrics renders the connection pool statistics
+func writeDatabaseMetrics(w *bufio.Writer) {
+	sqlDB, err := database.GetDB().DB()
+	if err != nil {
+		log.Printf("Failed to get database pool for metrics: %v", err)
+		return
+	}
+	poolStats := sqlDB.Stats()
+
+	gauges := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"dockformer_db_max_open_connections", "Maximum number of open database connections.", float64(poolStats.MaxOpenConnections)},
+		{"dockformer_db_open_connections", "Open database connections.", float64(poolStats.OpenConnections)},
+		{"dockformer_db_in_use_connections", "Database connections in use.", float64(poolStats.InUse)},
+		{"dockformer_db_idle_connections", "Idle database connections.", float64(poolStats.Idle)},
+		{"dockformer_db_wait_count_total", "Times a query waited for a database connection.", float64(poolStats.WaitCount)},
+		{"dockformer_db_wait_duration_seconds_total", "Time spent waiting for database connections.", poolStats.WaitDuration.Seconds()},
+	}
+	for _, gauge := range gauges {
+		writeGauge(w, gauge.name, gauge.help, []gaugeSample{{value: gauge.value}})
+	}
+}
+
+// writeContainerMetrics renders the state and usage of every managed
+// container, labelled by name, image and stack
+func writeContainerMetrics(w *bufio.Writer) {
+	var containerList []models.Container
+	if err := database.GetDB().Order("name").Find(&containerList).Error; err != nil {
+		log.Printf("Failed to load containers for metrics: %v", err)
+		return
+	}
+
+	var stackList []models.Stack
+	if err := database.GetDB().Find(&stackList).Error; err != nil {
+		log.Printf("Failed to load stacks for metrics: %v", err)
+		return
+	}
+	stackNames := make(map[uint]string, len(stackList))
+	for _, stack := range stackList {
+		stackNames[stack.ID] = stack.Name
+	}
+
+	labels := []string{"name", "image", "stack"}
+	usage := stats.latestAll()
+
+	var up, status, health, restarts, cpu, memory, memoryLimit []gaugeSample
+	for _, containerObj := range containerList {
+		stackName := ""
+		if containerObj.StackID != nil {
+			stackName = stackNames[*containerObj.StackID]
+		}
+		values := []string{containerObj.Name, containerObj.Image, stackName}
+
+		running := 0.0
+		if containerObj.Status == models.StatusRunning {
+			running = 1
+		}
+		up = append(up, gaugeSample{labels, values, running})
+		status = append(status, gaugeSample{append(labels, "status"), append(values, string(containerObj.Status)), 1})
+		health = append(health, gaugeSample{append(labels, "health"), append(values, string(containerObj.Health)), 1})
+		restarts = append(restarts, gaugeSample{labels, values, float64(containerObj.RestartCount)})
+
+		if sample, ok := usage[containerObj.Name]; ok && running == 1 {
+			cpu = append(cpu, gaugeSample{labels, values, sample.CPUPercent})
+			memory = append(memory, gaugeSample{labels, values, float64(sample.MemoryUsage)})
+			memoryLimit = append(memoryLimit, gaugeSample{labels, values, float64(sample.MemoryLimit)})
+		}
+	}
+
+	writeGauge(w, "dockformer_container_up", "Whether the container is running.", up)
+	writeGauge(w, "dockformer_container_status", "Current status of the container, always 1.", status)
+	writeGauge(w, "dockformer_container_health", "Current health of the container, always 1.", health)
+	writeGauge(w, "dockformer_container_restarts", "Times Docker restarted the container.", restarts)
+	writeGauge(w, "dockformer_container_cpu_percent", "CPU usage of the container in percent of one CPU.", cpu)
+	writeGauge(w, "dockformer_container_memory_usage_bytes", "Memory used by the container, excluding the page cache.", memory)
+	writeGauge(w, "dockformer_container_memory_limit_bytes", "Memory limit of the container.", memoryLimit)
+}
+
+// formatLabels renders a label set such as {name="web",stack="app"}
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + labelEscaper.Replace(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue renders a sample value
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
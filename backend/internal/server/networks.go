@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"log"
+	"sort"
+)
+
+// NetworkConfig represents the YAML configuration of a user-defined network.
+// External networks are managed outside the stack: they must already exist
+// and are never created or removed with it.
+type NetworkConfig struct {
+	Driver   string `yaml:"driver,omitempty"`
+	Subnet   string `yaml:"subnet,omitempty"`
+	Internal bool   `yaml:"internal,omitempty"`
+	External bool   `yaml:"external,omitempty"`
+}
+
+// Helper functions
+
+// stackNetworks returns the names of every network the stack uses, whether
+// declared in the top-level networks section or only referenced by a container
+func stackNetworks(config ContainersConfig) []string {
+	seen := make(map[string]bool)
+	for name := range config.Networks {
+		seen[name] = true
+	}
+	for _, containerConfig := range config.Containers {
+		for _, name := range containerConfig.Networks {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ensureNetworks creates the networks used by the stack that don't exist yet.
+// Networks created here are labelled with the stack so they can be cleaned up
+// when the stack is removed. A missing external network is an error. The
+// names of the created networks are returned, including the ones created
+// before an error.
+func ensureNetworks(stackName string, config ContainersConfig) ([]string, error) {
+	ctx := context.Background()
+
+	var created []string
+	for _, name := range stackNetworks(config) {
+		_, err := dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !errdefs.IsNotFound(err) {
+			return created, fmt.Errorf("failed to inspect network '%s': %w", name, err)
+		}
+
+		networkConfig := config.Networks[name]
+		if networkConfig.External {
+			return created, fmt.Errorf("external network '%s' does not exist", name)
+		}
+		options := network.CreateOptions{
+			Driver:   networkConfig.Driver,
+			Internal: networkConfig.Internal,
+			Labels:   map[string]string{labelStack: stackName},
+		}
+		if networkConfig.Subnet != "" {
+			options.IPAM = &network.IPAM{
+				Config: []network.IPAMConfig{{Subnet: networkConfig.Subnet}},
+			}
+		}
+
+		if _, err := dockerClient.NetworkCreate(ctx, name, options); err != nil {
+			return created, fmt.Errorf("failed to create network '%s': %w", name, err)
+		}
+		created = append(created, name)
+		log.Printf("Created network %s for stack %s", name, stackName)
+	}
+
+	return created, nil
+}
+
+// connectNetworks attaches a container to every network after the first,
+// which is set when the container is created. The container's name is used
+// as its alias so other services can reach it by name.
+func connectNetworks(containerID string, config ContainerConfig) error {
+	if len(config.Networks) < 2 {
+		return nil
+	}
+
+	ctx := context.Background()
+	for _, name := range config.Networks[1:] {
+		endpoint := &network.EndpointSettings{Aliases: []string{config.Name}}
+		if err := dockerClient.NetworkConnect(ctx, name, containerID, endpoint); err != nil {
+			return fmt.Errorf("failed to connect to network '%s': %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// removeStackNetworks removes the networks created for a stack once no
+// container is attached to them anymore
+func removeStackNetworks(stackName string) {
+	ctx := context.Background()
+
+	networks, err := dockerClient.NetworkList(ctx, network.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", labelStack+"="+stackName)),
+	})
+	if err != nil {
+		log.Printf("Failed to list networks for stack %s: %v", stackName, err)
+		return
+	}
+
+	for _, summary := range networks {
+		// The list endpoint doesn't report attached containers
+		info, err := dockerClient.NetworkInspect(ctx, summary.ID, network.InspectOptions{})
+		if err != nil {
+			log.Printf("Failed to inspect network %s: %v", summary.Name, err)
+			continue
+		}
+		if len(info.Containers) > 0 {
+			log.Printf("Keeping network %s, still in use by %d container(s)", info.Name, len(info.Containers))
+			continue
+		}
+
+		if err := dockerClient.NetworkRemove(ctx, summary.ID); err != nil {
+			log.Printf("Failed to remove network %s: %v", summary.Name, err)
+		}
+	}
+}
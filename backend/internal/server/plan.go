@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// FieldChange describes a single field that differs between the running
+// container and the desired configuration
+type FieldChange struct {
+	Field   string `json:"field"`
+	Current string `json:"current"`
+	Desired string `json:"desired"`
+}
+
+// PlanEntry describes what applying the YAML would do to one container
+type PlanEntry struct {
+	Name    string        `json:"name"`
+	Image   string        `json:"image"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// Plan is the structured diff between a YAML file and the containers that
+// currently exist in Docker
+type Plan struct {
+	Stack     string      `json:"stack"`
+	Create    []PlanEntry `json:"create"`
+	Recreate  []PlanEntry `json:"recreate"`
+	Unchanged []PlanEntry `json:"unchanged"`
+	Orphaned  []PlanEntry `json:"orphaned"`
+}
+
+// API handlers
+func planHandler(c *gin.Context) {
+	yamlData, defaultName, err := readYamlRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	config, err := parseContainersConfig(yamlData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, configErrorBody(err))
+		return
+	}
+
+	plan, err := buildPlan(config.stackName(defaultName), config)
+	if err != nil {
+		c.JSON(applyErrorStatus(err), applyErrorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+// Helper functions
+
+// buildPlan compares the desired containers with what Docker reports and
+// classifies each one as create, recreate, unchanged or orphaned. Like an
+// apply, it fails with a *ConflictError when containers belong to other
+// stacks.
+func buildPlan(stackName string, config ContainersConfig) (*Plan, error) {
+	ctx := context.Background()
+	if err := checkNameConflicts(ctx, stackName, config.Containers); err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Stack:     stackName,
+		Create:    []PlanEntry{},
+		Recreate:  []PlanEntry{},
+		Unchanged: []PlanEntry{},
+		Orphaned:  []PlanEntry{},
+	}
+
+	desired := make(map[string]bool)
+	for _, containerConfig := range config.Containers {
+		desired[containerConfig.Name] = true
+		entry := PlanEntry{Name: containerConfig.Name, Image: containerConfig.Image}
+
+		info, err := dockerClient.ContainerInspect(ctx, containerConfig.Name)
+		if errdefs.IsNotFound(err) {
+			plan.Create = append(plan.Create, entry)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect container '%s': %w", containerConfig.Name, err)
+		}
+
+		// Containers created from an identical spec are unchanged
+		hash := specHash(containerConfig)
+		currentHash := info.Config.Labels[labelSpecHash]
+		if currentHash == hash {
+			plan.Unchanged = append(plan.Unchanged, entry)
+			continue
+		}
+
+		changes, err := diffContainer(info, containerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("container '%s': %w", containerConfig.Name, err)
+		}
+
+		// The spec changed in a way the field comparison can't see, such as
+		// a removed environment variable
+		if len(changes) == 0 {
+			changes = []FieldChange{{Field: "spec", Current: currentHash, Desired: hash}}
+		}
+		entry.Changes = changes
+		plan.Recreate = append(plan.Recreate, entry)
+	}
+
+	// Containers previously deployed with the stack but missing from the YAML
+	var stack models.Stack
+	err := database.GetDB().Preload("Containers").Where("name = ?", stackName).First(&stack).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	for _, containerObj := range stack.Containers {
+		if !desired[containerObj.Name] {
+			plan.Orphaned = append(plan.Orphaned, PlanEntry{Name: containerObj.Name, Image: containerObj.Image})
+		}
+	}
+
+	return plan, nil
+}
+
+// diffContainer lists the fields of an existing container that differ from
+// the desired configuration
+func diffContainer(info container.InspectResponse, config ContainerConfig) ([]FieldChange, error) {
+	var changes []FieldChange
+
+	if info.Config.Image != config.Image {
+		changes = append(changes, FieldChange{Field: "image", Current: info.Config.Image, Desired: config.Image})
+	}
+
+	_, portBindings, err := parsePorts(config.Ports)
+	if err != nil {
+		return nil, err
+	}
+	currentPorts := formatPortBindings(info.HostConfig.PortBindings)
+	desiredPorts := formatPortBindings(portBindings)
+	if currentPorts != desiredPorts {
+		changes = append(changes, FieldChange{Field: "ports", Current: currentPorts, Desired: desiredPorts})
+	}
+
+	// The image contributes its own environment, so only check that every
+	// desired variable is present with the desired value
+	var missingEnv []string
+	for k, v := range config.Env {
+		if !slices.Contains(info.Config.Env, k+"="+v) {
+			missingEnv = append(missingEnv, k+"="+v)
+		}
+	}
+	if len(missingEnv) > 0 {
+		sort.Strings(missingEnv)
+		changes = append(changes, FieldChange{
+			Field:   "env",
+			Current: strings.Join(envKeys(info.Config.Env), ","),
+			Desired: strings.Join(missingEnv, ","),
+		})
+	}
+
+	currentVolumes := sortedJoin(info.HostConfig.Binds)
+	desiredVolumes := sortedJoin(config.Volumes)
+	if currentVolumes != desiredVolumes {
+		changes = append(changes, FieldChange{Field: "volumes", Current: currentVolumes, Desired: desiredVolumes})
+	}
+
+	// Without networks the container lands on the default bridge
+	if len(config.Networks) > 0 {
+		var currentNetworks []string
+		if info.NetworkSettings != nil {
+			for name := range info.NetworkSettings.Networks {
+				currentNetworks = append(currentNetworks, name)
+			}
+		}
+		current := sortedJoin(currentNetworks)
+		desired := sortedJoin(config.Networks)
+		if current != desired {
+			changes = append(changes, FieldChange{Field: "networks", Current: current, Desired: desired})
+		}
+	}
+
+	// An empty command means "use the image default", which we can't compare
+	if config.Command != "" {
+		currentCommand := strings.Join(info.Config.Cmd, " ")
+		if currentCommand != config.Command {
+			changes = append(changes, FieldChange{Field: "command", Current: currentCommand, Desired: config.Command})
+		}
+	}
+
+	return changes, nil
+}
+
+// formatPortBindings renders port bindings as a sorted "host:container/proto" list
+func formatPortBindings(portMap nat.PortMap) string {
+	var ports []string
+	for port, bindings := range portMap {
+		for _, binding := range bindings {
+			ports = append(ports, binding.HostPort+":"+string(port))
+		}
+	}
+	return sortedJoin(ports)
+}
+
+// envKeys returns the variable names of KEY=VALUE environment entries
+func envKeys(env []string) []string {
+	keys := make([]string, 0, len(env))
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedJoin joins a copy of values in sorted order so lists can be compared
+func sortedJoin(values []string) string {
+	sorted := slices.Clone(values)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
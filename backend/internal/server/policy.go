@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/distribution/reference"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PolicyFile is the YAML file named by DOCKFORMER_POLICY_FILE
+type PolicyFile struct {
+	Policies []Policy `yaml:"policies"`
+}
+
+// Policy is a named set of admission rules every container must satisfy
+// before it is deployed. Rules left empty are not checked.
+type Policy struct {
+	Name string `yaml:"name" json:"name"`
+	// AllowedImages are image patterns, where * matches any characters,
+	// such as "registry.example.com/*" or "docker.io/library/nginx:*"
+	AllowedImages []string `yaml:"allowed_images,omitempty" json:"allowed_images,omitempty"`
+	// ForbiddenHostPaths may not be bind-mounted, nor may anything inside
+	// them or any directory containing them
+	ForbiddenHostPaths []string `yaml:"forbidden_host_paths,omitempty" json:"forbidden_host_paths,omitempty"`
+	// AllowedHostPorts are ports or ranges such as "8000-8999" that may be
+	// published on the host
+	AllowedHostPorts []string `yaml:"allowed_host_ports,omitempty" json:"allowed_host_ports,omitempty"`
+	// RequiredLabels are label keys, or key=value pairs, every container
+	// must carry
+	RequiredLabels []string `yaml:"required_labels,omitempty" json:"required_labels,omitempty"`
+	// MaxMemory and MaxCPUs require a limit no higher than themselves
+	MaxMemory string `yaml:"max_memory,omitempty" json:"max_memory,omitempty"`
+	MaxCPUs   string `yaml:"max_cpus,omitempty" json:"max_cpus,omitempty"`
+	// ForbidLatestTag rejects images tagged latest or without a tag
+	ForbidLatestTag bool `yaml:"forbid_latest_tag,omitempty" json:"forbid_latest_tag,omitempty"`
+
+	// Parsed forms of the rules above
+	imagePatterns []*regexp.Regexp
+	portRanges    [][2]int
+	maxMemory     int64
+	maxCPUs       float64
+}
+
+// PolicyViolation is a rule a container breaks
+type PolicyViolation struct {
+	Policy    string `json:"policy"`
+	Container string `json:"container"`
+	Field     string `json:"field"`
+	Message   string `json:"message"`
+}
+
+// PolicyError is returned when a stack breaks admission policies
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+// Error summarizes the violations, one per line
+func (e *PolicyError) Error() string {
+	lines := []string{fmt.Sprintf("Rejected by policy: %d violation(s) found", len(e.Violations))}
+	for _, violation := range e.Violations {
+		lines = append(lines, fmt.Sprintf("%s: %s: %s (%s)", violation.Container, violation.Field, violation.Message, violation.Policy))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// policySet holds the policies loaded from DOCKFORMER_POLICY_FILE
+type policySet struct {
+	mu       sync.RWMutex
+	path     string
+	policies []Policy
+}
+
+// policies is the set evaluated before every deploy
+var policies = &policySet{}
+
+// API handlers
+func getPolicies(c *gin.Context) {
+	path, loaded := policies.list()
+	c.JSON(http.StatusOK, gin.H{
+		"file":     path,
+		"policies": loaded,
+	})
+}
+
+func reloadPolicies(c *gin.Context) {
+	if err := policies.load(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	path, loaded := policies.list()
+	c.JSON(http.StatusOK, gin.H{
+		"file":     path,
+		"policies": loaded,
+	})
+}
+
+// Helper functions
+
+// load reads the policies from DOCKFORMER_POLICY_FILE, replacing the ones
+// loaded before. Without the variable no policies apply. A file that fails
+// to load leaves the current policies in place.
+func (s *policySet) load() error {
+	path := os.Getenv("DOCKFORMER_POLICY_FILE")
+	if path == "" {
+		s.mu.Lock()
+		s.path, s.policies = "", nil
+		s.mu.Unlock()
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var file PolicyFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+
+	names := make(map[string]bool, len(file.Policies))
+	for i := range file.Policies {
+		policy := &file.Policies[i]
+		if policy.Name == "" {
+			return fmt.Errorf("policy %d in %s has no name", i+1, path)
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("duplicate policy '%s' in %s", policy.Name, path)
+		}
+		names[policy.Name] = true
+
+		if err := policy.compile(); err != nil {
+			return fmt.Errorf("invalid policy '%s' in %s: %w", policy.Name, path, err)
+		}
+	}
+
+	s.mu.Lock()
+	s.path, s.policies = path, file.Policies
+	s.mu.Unlock()
+
+	log.Printf("Loaded %d policies from %s", len(file.Policies), path)
+	return nil
+}
+
+// list returns the policy file and its policies
+func (s *policySet) list() (string, []Policy) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.path, append([]Policy{}, s.policies...)
+}
+
+// check evaluates every policy against every container of a stack,
+// returning a PolicyError listing all violations
+func (s *policySet) check(containers []ContainerConfig) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var violations []PolicyViolation
+	for _, containerConfig := range containers {
+		for _, policy := range s.policies {
+			violations = append(violations, policy.evaluate(containerConfig)...)
+		}
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// compile parses the rules of a policy
+func (p *Policy) compile() error {
+	for _, pattern := range p.AllowedImages {
+		expression := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		p.imagePatterns = append(p.imagePatterns, regexp.MustCompile(expression))
+	}
+
+	for _, hostPath := range p.ForbiddenHostPaths {
+		if !strings.HasPrefix(hostPath, "/") {
+			return fmt.Errorf("forbidden host path '%s' must be absolute", hostPath)
+		}
+	}
+
+	for _, portRange := range p.AllowedHostPorts {
+		low, high, isRange := strings.Cut(portRange, "-")
+		if !isRange {
+			high = low
+		}
+		first, err1 := strconv.Atoi(strings.TrimSpace(low))
+		last, err2 := strconv.Atoi(strings.TrimSpace(high))
+		if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+			return fmt.Errorf("invalid host port range '%s', expected PORT or FIRST-LAST", portRange)
+		}
+		p.portRanges = append(p.portRanges, [2]int{first, last})
+	}
+
+	var err error
+	if p.maxMemory, err = parseMemory("max_memory", p.MaxMemory); err != nil {
+		return err
+	}
+	if p.MaxCPUs != "" {
+		if p.maxCPUs, err = strconv.ParseFloat(p.MaxCPUs, 64); err != nil || p.maxCPUs <= 0 {
+			return fmt.Errorf("invalid max_cpus '%s', expected a positive number such as 0.5", p.MaxCPUs)
+		}
+	}
+	return nil
+}
+
+// evaluate returns the rules of the policy a container breaks
+func (p *Policy) evaluate(config ContainerConfig) []PolicyViolation {
+	var violations []PolicyViolation
+	violate := func(field string, format string, args ...interface{}) {
+		violations = append(violations, PolicyViolation{
+			Policy:    p.Name,
+			Container: config.Name,
+			Field:     field,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Images, compared in both their short and their fully qualified form
+	named, err := reference.ParseNormalizedNamed(config.Image)
+	if err != nil {
+		violate("image", "invalid image reference '%s'", config.Image)
+		return violations
+	}
+	if len(p.imagePatterns) > 0 {
+		forms := []string{config.Image, reference.FamiliarString(named), reference.TagNameOnly(named).String()}
+		allowed := false
+		for _, pattern := range p.imagePatterns {
+			for _, form := range forms {
+				allowed = allowed || pattern.MatchString(form)
+			}
+		}
+		if !allowed {
+			violate("image", "image '%s' does not match any of %s", config.Image, strings.Join(p.AllowedImages, ", "))
+		}
+	}
+	if p.ForbidLatestTag {
+		_, digested := named.(reference.Digested)
+		tagged, hasTag := named.(reference.Tagged)
+		if !digested && (!hasTag || tagged.Tag() == "latest") {
+			violate("image", "image '%s' must be pinned to a tag other than latest", config.Image)
+		}
+	}
+
+	// Bind mounts
+	for _, volume := range config.Volumes {
+		source, _, _ := strings.Cut(volume, ":")
+		if !strings.HasPrefix(source, "/") {
+			continue
+		}
+		for _, forbidden := range p.ForbiddenHostPaths {
+			if pathsOverlap(source, forbidden) {
+				violate("volumes", "bind mount of '%s' exposes forbidden host path '%s'", source, forbidden)
+			}
+		}
+	}
+
+	// Published ports
+	if len(p.portRanges) > 0 {
+		for _, port := range strings.Split(config.Ports, ",") {
+			hostPort, _, ok := strings.Cut(strings.TrimSpace(port), ":")
+			if !ok {
+				continue
+			}
+			number, _ := strconv.Atoi(hostPort)
+			if !p.portAllowed(number) {
+				violate("ports", "host port %d is outside the allowed ranges %s", number, strings.Join(p.AllowedHostPorts, ", "))
+			}
+		}
+	}
+
+	// Labels
+	for _, required := range p.RequiredLabels {
+		key, value, hasValue := strings.Cut(required, "=")
+		actual, ok := config.Labels[key]
+		switch {
+		case !ok:
+			violate("labels", "label '%s' is required", key)
+		case hasValue && actual != value:
+			violate("labels", "label '%s' must be '%s', not '%s'", key, value, actual)
+		}
+	}
+
+	// Resource limits. Limits that fail to parse are reported by validation.
+	if p.maxMemory > 0 {
+		memory, _ := parseMemory("memory", config.Memory)
+		switch {
+		case memory == 0:
+			violate("memory", "a memory limit of at most %s is required", p.MaxMemory)
+		case memory > p.maxMemory:
+			violate("memory", "memory limit %s exceeds the maximum of %s", config.Memory, p.MaxMemory)
+		}
+	}
+	if p.maxCPUs > 0 {
+		cpus, _ := strconv.ParseFloat(config.CPUs, 64)
+		switch {
+		case cpus == 0:
+			violate("cpus", "a CPU limit of at most %s is required", p.MaxCPUs)
+		case cpus > p.maxCPUs:
+			violate("cpus", "CPU limit %s exceeds the maximum of %s", config.CPUs, p.MaxCPUs)
+		}
+	}
+
+	return violations
+}
+
+// portAllowed reports whether a host port lies in one of the allowed ranges
+func (p *Policy) portAllowed(port int) bool {
+	for _, portRange := range p.portRanges {
+		if port >= portRange[0] && port <= portRange[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// pathsOverlap reports whether mounting source would expose forbidden,
+// because they are the same path or one contains the other. Both paths are
+// compared as written and with symlinks resolved, so /var/run/docker.sock
+// matches /run/docker.sock where /var/run links to /run. Symlinks are
+// resolved on the DockFormer host, which must share the Docker host's
+// filesystem for this to hold, and only as far as the paths exist: a link
+// created after the check, or below a directory that doesn't exist yet,
+// isn't seen.
+func pathsOverlap(source string, forbidden string) bool {
+	for _, s := range hostPathForms(source) {
+		for _, f := range hostPathForms(forbidden) {
+			if s == f || isParentPath(s, f) || isParentPath(f, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostPathForms returns a cleaned absolute path along with the path it
+// resolves to on the host, if that differs
+func hostPathForms(hostPath string) []string {
+	hostPath = path.Clean(hostPath)
+	if resolved := resolveHostPath(hostPath); resolved != hostPath {
+		return []string{hostPath, resolved}
+	}
+	return []string{hostPath}
+}
+
+// resolveHostPath resolves the symlinks of the longest existing prefix of
+// a cleaned absolute path, keeping the rest as it is
+func resolveHostPath(hostPath string) string {
+	existing, rest := hostPath, ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			return path.Join(filepath.ToSlash(resolved), rest)
+		}
+		if existing == "/" {
+			return hostPath
+		}
+		existing, rest = path.Dir(existing), path.Join(path.Base(existing), rest)
+	}
+}
+
+// isParentPath reports whether child lies inside parent
+func isParentPath(parent string, child string) bool {
+	if parent == "/" {
+		return child != "/"
+	}
+	return strings.HasPrefix(child, parent+"/")
+}
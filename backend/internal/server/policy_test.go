@@ -0,0 +1,169 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPathsOverlap(t *testing.T) {
+	// real holds a socket file and link points at real
+	dir := t.TempDir()
+	real := filepath.Join(dir, "real")
+	link := filepath.Join(dir, "link")
+	if err := os.Mkdir(real, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(real, "docker.sock"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		source    string
+		forbidden string
+		expected  bool
+	}{
+		{"same path", "/var/run/docker.sock", "/var/run/docker.sock", true},
+		{"unclean path", "/var/run/../run/./docker.sock/", "/var/run/docker.sock", true},
+		{"inside forbidden directory", "/etc/ssh/sshd_config", "/etc", true},
+		{"containing forbidden path", "/var", "/var/run/docker.sock", true},
+		{"root", "/", "/etc", true},
+		{"sibling", "/etc2", "/etc", false},
+		{"unrelated", "/srv/data", "/var/run/docker.sock", false},
+		{"source through symlink", filepath.Join(link, "docker.sock"), filepath.Join(real, "docker.sock"), true},
+		{"forbidden through symlink", filepath.Join(real, "docker.sock"), filepath.Join(link, "docker.sock"), true},
+		{"directory through symlink", link, filepath.Join(real, "docker.sock"), true},
+		{"missing path below symlink", filepath.Join(link, "new", "file"), real, true},
+		{"missing sibling of symlink target", filepath.Join(dir, "other"), real, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if overlap := pathsOverlap(test.source, test.forbidden); overlap != test.expected {
+				t.Errorf("pathsOverlap(%q, %q) = %v, expected %v", test.source, test.forbidden, overlap, test.expected)
+			}
+		})
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy := Policy{
+		Name:               "prod",
+		AllowedImages:      []string{"registry.example.com/*", "docker.io/library/nginx:*"},
+		ForbiddenHostPaths: []string{"/var/run/docker.sock"},
+		AllowedHostPorts:   []string{"80", "8000-8999"},
+		RequiredLabels:     []string{"team", "env=prod"},
+		MaxMemory:          "512m",
+		MaxCPUs:            "1",
+		ForbidLatestTag:    true,
+	}
+	if err := policy.compile(); err != nil {
+		t.Fatalf("failed to compile policy: %v", err)
+	}
+
+	compliant := ContainerConfig{
+		Name:   "web",
+		Image:  "nginx:1.27",
+		Ports:  "8080:80, 80:8080",
+		Labels: map[string]string{"team": "web", "env": "prod"},
+		Memory: "256m",
+		CPUs:   "0.5",
+	}
+
+	tests := []struct {
+		name     string
+		change   func(config *ContainerConfig)
+		expected []string
+	}{
+		{
+			name:   "compliant",
+			change: func(config *ContainerConfig) {},
+		},
+		{
+			name:   "fully qualified allowed image",
+			change: func(config *ContainerConfig) { config.Image = "registry.example.com/shop/app:2.1" },
+		},
+		{
+			name:     "image not allowed",
+			change:   func(config *ContainerConfig) { config.Image = "redis:7" },
+			expected: []string{"image"},
+		},
+		{
+			name:     "latest tag",
+			change:   func(config *ContainerConfig) { config.Image = "nginx:latest" },
+			expected: []string{"image"},
+		},
+		{
+			name:     "no tag",
+			change:   func(config *ContainerConfig) { config.Image = "nginx" },
+			expected: []string{"image"},
+		},
+		{
+			name: "pinned by digest",
+			change: func(config *ContainerConfig) {
+				config.Image = "registry.example.com/shop/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+			},
+		},
+		{
+			name:     "invalid image",
+			change:   func(config *ContainerConfig) { config.Image = "Not An Image" },
+			expected: []string{"image"},
+		},
+		{
+			name:     "forbidden bind mount",
+			change:   func(config *ContainerConfig) { config.Volumes = []string{"/var/run:/host/run:ro", "data:/data"} },
+			expected: []string{"volumes"},
+		},
+		{
+			name:     "host port outside ranges",
+			change:   func(config *ContainerConfig) { config.Ports = "8080:80,9000:9000,22:22" },
+			expected: []string{"ports", "ports"},
+		},
+		{
+			name:   "container port only",
+			change: func(config *ContainerConfig) { config.Ports = "9000" },
+		},
+		{
+			name:     "missing label",
+			change:   func(config *ContainerConfig) { delete(config.Labels, "team") },
+			expected: []string{"labels"},
+		},
+		{
+			name:     "wrong label value",
+			change:   func(config *ContainerConfig) { config.Labels["env"] = "dev" },
+			expected: []string{"labels"},
+		},
+		{
+			name:     "no limits",
+			change:   func(config *ContainerConfig) { config.Memory, config.CPUs = "", "" },
+			expected: []string{"memory", "cpus"},
+		},
+		{
+			name:     "limits too high",
+			change:   func(config *ContainerConfig) { config.Memory, config.CPUs = "1g", "2" },
+			expected: []string{"memory", "cpus"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := compliant
+			config.Labels = map[string]string{"team": "web", "env": "prod"}
+			test.change(&config)
+
+			var fields []string
+			for _, violation := range policy.evaluate(config) {
+				if violation.Policy != "prod" || violation.Container != "web" || violation.Message == "" {
+					t.Errorf("incomplete violation %+v", violation)
+				}
+				fields = append(fields, violation.Field)
+			}
+			if !reflect.DeepEqual(fields, test.expected) {
+				t.Errorf("expected violations of %v, got %v", test.expected, fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+)
+
+// stackResolver returns the name of the stack a request acts on, or "" if
+// it doesn't act on a stack
+type stackResolver func(c *gin.Context) string
+
+// RoleRequest is the body of a request changing a role
+type RoleRequest struct {
+	Role models.Role `json:"role" binding:"required"`
+}
+
+// GrantResponse is a stack grant as returned by the API
+type GrantResponse struct {
+	Stack string      `json:"stack"`
+	Role  models.Role `json:"role"`
+}
+
+// API handlers
+func updateUserRole(c *gin.Context) {
+	user, ok := grantUser(c)
+	if !ok {
+		return
+	}
+
+	var request RoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !request.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be 'viewer', 'operator' or 'admin'"})
+		return
+	}
+	if user.ID == currentUser(c).ID && request.Role != models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot remove your own admin role"})
+		return
+	}
+
+	if err := database.GetDB().Model(user).Update("role", request.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user.Role = request.Role
+
+	c.JSON(http.StatusOK, userResponse(*user))
+}
+
+func getUserGrants(c *gin.Context) {
+	user, ok := grantUser(c)
+	if !ok {
+		return
+	}
+
+	var grants []models.StackGrant
+	if err := database.GetDB().Where("user_id = ?", user.ID).Order("stack_name").Find(&grants).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := make([]GrantResponse, len(grants))
+	for i, grant := range grants {
+		response[i] = GrantResponse{Stack: grant.StackName, Role: grant.Role}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func putUserGrant(c *gin.Context) {
+	user, ok := grantUser(c)
+	if !ok {
+		return
+	}
+
+	var request RoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !request.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be 'viewer', 'operator' or 'admin'"})
+		return
+	}
+
+	grant := models.StackGrant{UserID: user.ID, StackName: c.Param("stack")}
+	err := database.GetDB().
+		Where(models.StackGrant{UserID: grant.UserID, StackName: grant.StackName}).
+		Assign(models.StackGrant{Role: request.Role}).
+		FirstOrCreate(&grant).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, GrantResponse{Stack: grant.StackName, Role: grant.Role})
+}
+
+func deleteUserGrant(c *gin.Context) {
+	user, ok := grantUser(c)
+	if !ok {
+		return
+	}
+
+	result := database.GetDB().Where("user_id = ? AND stack_name = ?", user.ID, c.Param("stack")).Delete(&models.StackGrant{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Grant not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Grant removed successfully"})
+}
+
+// Helper functions
+
+// requireRole allows requests by users whose own role is at least role
+func requireRole(role models.Role) gin.HandlerFunc {
+	return authorize(role, nil)
+}
+
+// requireStackRole allows requests by users with at least role on the stack
+// the request acts on, through their own role or a grant on that stack.
+// Requests for resources outside any stack need the user's own role.
+func requireStackRole(role models.Role, stackOf stackResolver) gin.HandlerFunc {
+	return authorize(role, stackOf)
+}
+
+// authorize checks the authenticated user's role and denies the request
+// with a 403 if it falls short
+func authorize(required models.Role, stackOf stackResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil {
+			denyRequest(c, required, "", "not signed in")
+			return
+		}
+
+		stackName := ""
+		if stackOf != nil {
+			stackName = stackOf(c)
+		}
+
+		role, err := effectiveRole(user, stackName)
+		if err != nil {
+			log.Printf("Failed to load grants of %s: %v", user.Username, err)
+			abortWithError(c, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !role.Allows(required) {
+			denyRequest(c, required, stackName, fmt.Sprintf("has role %s", role))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// effectiveRole returns the higher of a user's own role and their grant on
+// a stack
+func effectiveRole(user *models.User, stackName string) (models.Role, error) {
+	if stackName == "" || user.Role.Allows(models.RoleAdmin) {
+		return user.Role, nil
+	}
+
+	var grant models.StackGrant
+	err := database.GetDB().Where("user_id = ? AND stack_name = ?", user.ID, stackName).First(&grant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user.Role, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if grant.Role.Allows(user.Role) {
+		return grant.Role, nil
+	}
+	return user.Role, nil
+}
+
+// denyRequest audits a denied request and responds with a 403, as JSON for
+// API requests and as the error page for the UI
+func denyRequest(c *gin.Context, required models.Role, stackName string, reason string) {
+	detail := fmt.Sprintf("requires %s, %s", required, reason)
+	if stackName != "" {
+		detail = fmt.Sprintf("requires %s on stack '%s', %s", required, stackName, reason)
+	}
+
+	// Audited routes record the denial along with their action, others are
+	// recorded here
+	if _, audited := c.Get(auditEventKey); audited {
+		c.Set(auditDeniedKey, detail)
+	} else {
+		event := newAuditEvent(c, c.Request.Method+" "+c.FullPath())
+		event.Stack = stackName
+		event.PayloadDigest = payloadDigest(c)
+		event.Status = http.StatusForbidden
+		event.Outcome = models.AuditDenied
+		event.Error = detail
+		recordAuditEvent(event)
+	}
+
+	message := fmt.Sprintf("Permission denied: this action requires the %s role", required)
+	if stackName != "" {
+		message = fmt.Sprintf("Permission denied: this action requires the %s role on stack '%s'", required, stackName)
+	}
+	abortWithError(c, http.StatusForbidden, message)
+}
+
+// abortWithError ends a request with an error, as JSON for API requests
+// and as the error page for the UI
+func abortWithError(c *gin.Context, status int, message string) {
+	if isAPIRequest(c) {
+		c.AbortWithStatusJSON(status, gin.H{"error": message})
+		return
+	}
+	c.HTML(status, "error.html", gin.H{
+		"error": message,
+	})
+	c.Abort()
+}
+
+// stackFromParam resolves the stack named in the :name parameter
+func stackFromParam(c *gin.Context) string {
+	return c.Param("name")
+}
+
+// stackFromContainer resolves the stack of the container in the :id
+// parameter. Containers that can't be loaded resolve to no stack, which
+// only lets the user's own role through, and are left for the handler to
+// report.
+func stackFromContainer(c *gin.Context) string {
+	_, stackName := containerTarget(c)
+	return stackName
+}
+
+// grantUser loads the user in the :id parameter, writing a 404 when it
+// doesn't exist
+func grantUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	if err := database.GetDB().First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UlimitConfig represents a soft and hard limit. In YAML it is either a
+// single number used for both, or a map with soft and hard keys.
+type UlimitConfig struct {
+	Soft int64 `yaml:"soft"`
+	Hard int64 `yaml:"hard"`
+}
+
+// UnmarshalYAML accepts both the number and the map form of a ulimit
+func (u *UlimitConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var limit int64
+		if err := value.Decode(&limit); err != nil {
+			return err
+		}
+		u.Soft, u.Hard = limit, limit
+		return nil
+	}
+
+	type plain UlimitConfig
+	return value.Decode((*plain)(u))
+}
+
+// Helper functions
+
+// parseRestartPolicy parses a restart policy such as "always" or "on-failure:3"
+func parseRestartPolicy(policy string) (container.RestartPolicy, error) {
+	name, retries, hasRetries := strings.Cut(policy, ":")
+
+	switch container.RestartPolicyMode(name) {
+	case "", container.RestartPolicyDisabled:
+		if hasRetries {
+			break
+		}
+		return container.RestartPolicy{Name: container.RestartPolicyDisabled}, nil
+	case container.RestartPolicyAlways, container.RestartPolicyUnlessStopped:
+		if hasRetries {
+			break
+		}
+		return container.RestartPolicy{Name: container.RestartPolicyMode(name)}, nil
+	case container.RestartPolicyOnFailure:
+		restartPolicy := container.RestartPolicy{Name: container.RestartPolicyOnFailure}
+		if hasRetries {
+			count, err := strconv.Atoi(retries)
+			if err != nil || count < 0 {
+				return container.RestartPolicy{}, fmt.Errorf("invalid restart retry count '%s'", retries)
+			}
+			restartPolicy.MaximumRetryCount = count
+		}
+		return restartPolicy, nil
+	}
+
+	return container.RestartPolicy{}, fmt.Errorf("invalid restart policy '%s', expected no, always, on-failure[:N] or unless-stopped", policy)
+}
+
+// parseMemory parses a human-readable memory size such as "512m" or "1g"
+func parseMemory(field string, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	bytes, err := units.RAMInBytes(value)
+	if err != nil || bytes <= 0 {
+		return 0, fmt.Errorf("invalid %s '%s', expected a size such as 512m or 1g", field, value)
+	}
+	return bytes, nil
+}
+
+// resources converts the restart policy and resource limits of a container
+// configuration into Docker's representation, validating them on the way
+func (config ContainerConfig) resources() (container.RestartPolicy, container.Resources, error) {
+	var resources container.Resources
+
+	restartPolicy, err := parseRestartPolicy(config.Restart)
+	if err != nil {
+		return container.RestartPolicy{}, resources, err
+	}
+
+	if resources.Memory, err = parseMemory("memory", config.Memory); err != nil {
+		return restartPolicy, resources, err
+	}
+	if resources.MemoryReservation, err = parseMemory("memory_reservation", config.MemoryReservation); err != nil {
+		return restartPolicy, resources, err
+	}
+	if resources.Memory > 0 && resources.MemoryReservation > resources.Memory {
+		return restartPolicy, resources, errors.New("memory_reservation must not exceed memory")
+	}
+
+	if config.CPUs != "" {
+		cpus, err := strconv.ParseFloat(config.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return restartPolicy, resources, fmt.Errorf("invalid cpus '%s', expected a positive number such as 0.5", config.CPUs)
+		}
+		resources.NanoCPUs = int64(cpus * 1e9)
+	}
+
+	if config.CPUShares < 0 {
+		return restartPolicy, resources, errors.New("cpu_shares must not be negative")
+	}
+	resources.CPUShares = config.CPUShares
+
+	if config.PidsLimit != 0 {
+		if config.PidsLimit < -1 {
+			return restartPolicy, resources, errors.New("pids_limit must be positive, or -1 for unlimited")
+		}
+		pidsLimit := config.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+
+	names := make([]string, 0, len(config.Ulimits))
+	for name := range config.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		limit := config.Ulimits[name]
+		if limit.Soft > limit.Hard {
+			return restartPolicy, resources, fmt.Errorf("ulimit '%s' soft limit exceeds hard limit", name)
+		}
+		resources.Ulimits = append(resources.Ulimits, &container.Ulimit{
+			Name: name,
+			Soft: limit.Soft,
+			Hard: limit.Hard,
+		})
+	}
+
+	return restartPolicy, resources, nil
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gopkg.in/yaml.v3"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxDiffCells bounds the table diffLines builds to compare two texts, 4 MiB
+// of memory. Larger changes are shown as removed and added wholesale.
+const maxDiffCells = 1 << 20
+
+// RevisionDiff compares the configuration of two revisions of a stack
+type RevisionDiff struct {
+	Stack   string      `json:"stack"`
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Added   []string    `json:"added"`
+	Removed []string    `json:"removed"`
+	Changed []PlanEntry `json:"changed"`
+	// YAML is a line diff of the source files, each line prefixed with
+	// "+", "-" or " "
+	YAML []string `json:"yaml"`
+}
+
+// API handlers
+func getStackRevisions(c *gin.Context) {
+	var revisions []models.StackRevision
+
+	result := database.GetDB().
+		Where("stack_name = ?", c.Param("name")).
+		Order("revision desc").
+		Find(&revisions)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+func getStackRevision(c *gin.Context) {
+	revision, err := findRevision(c.Param("name"), c.Param("rev"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+func diffStackRevisions(c *gin.Context) {
+	from, err := findRevision(c.Param("name"), c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := findRevision(c.Param("name"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	diff, err := diffRevisions(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+func rollbackStack(c *gin.Context) {
+	revision, err := findRevision(c.Param("name"), c.Param("rev"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A revision that failed to apply is unlikely to work now, so rolling
+	// back to it must be asked for explicitly
+	if revision.Result == models.RevisionFailed && c.Query("force") != "true" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("Revision %d failed to apply, pass force=true to roll back to it anyway", revision.Revision),
+		})
+		return
+	}
+
+	// Re-parse the stored YAML so the rollback goes through the same
+	// validation as an upload
+	config, err := parseContainersConfig([]byte(revision.SourceYAML))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, configErrorBody(err))
+		return
+	}
+
+	result, err := applyStack(revision.StackName, []byte(revision.SourceYAML), config, applyOptions{
+		Prune:      pruneRequested(c),
+		Actor:      requestActor(c),
+		RollbackOf: revision.Revision,
+	})
+	if err != nil {
+		c.JSON(applyErrorStatus(err), applyErrorBody(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Helper functions
+
+// requestActor identifies who made a request, for recording in revisions
+// and exec sessions
+func requestActor(c *gin.Context) string {
+	if user := currentUser(c); user != nil {
+		return user.Username
+	}
+	return c.ClientIP()
+}
+
+// findRevision loads a revision of a stack by its number
+func findRevision(stackName string, rev string) (*models.StackRevision, error) {
+	number, err := strconv.Atoi(rev)
+	if err != nil {
+		return nil, fmt.Errorf("invalid revision '%s'", rev)
+	}
+
+	var revision models.StackRevision
+	err = database.GetDB().
+		Where("stack_name = ? AND revision = ?", stackName, number).
+		First(&revision).Error
+	if err != nil {
+		return nil, fmt.Errorf("revision %d of stack '%s' not found", number, stackName)
+	}
+	return &revision, nil
+}
+
+// nextRevision returns the number of the next revision of a stack
+func nextRevision(stackName string) (int, error) {
+	var latest int
+	err := database.GetDB().Model(&models.StackRevision{}).
+		Where("stack_name = ?", stackName).
+		Select("COALESCE(MAX(revision), 0)").
+		Scan(&latest).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine revision of stack '%s': %w", stackName, err)
+	}
+	return latest + 1, nil
+}
+
+// recordRevision stores the outcome of applying a stack. Failing to record
+// the revision is logged but doesn't fail the apply, which already happened.
+func recordRevision(stackName string, number int, yamlData []byte, config ContainersConfig, options applyOptions, applyErr error) {
+	spec, err := json.Marshal(config)
+	if err != nil {
+		log.Printf("Failed to encode revision %d of stack %s: %v", number, stackName, err)
+		return
+	}
+
+	revision := models.StackRevision{
+		StackName:  stackName,
+		Revision:   number,
+		SourceYAML: string(yamlData),
+		Spec:       string(spec),
+		Actor:      options.Actor,
+		Result:     models.RevisionApplied,
+	}
+	if options.RollbackOf != 0 {
+		revision.RollbackOf = &options.RollbackOf
+	}
+	if applyErr != nil {
+		revision.Result = models.RevisionFailed
+		revision.Error = applyErr.Error()
+	}
+
+	if err := database.GetDB().Create(&revision).Error; err != nil {
+		log.Printf("Failed to record revision %d of stack %s: %v", number, stackName, err)
+	}
+}
+
+// diffRevisions compares the containers of two revisions field by field
+func diffRevisions(from, to *models.StackRevision) (*RevisionDiff, error) {
+	var fromConfig, toConfig ContainersConfig
+	if err := json.Unmarshal([]byte(from.Spec), &fromConfig); err != nil {
+		return nil, fmt.Errorf("failed to decode revision %d: %w", from.Revision, err)
+	}
+	if err := json.Unmarshal([]byte(to.Spec), &toConfig); err != nil {
+		return nil, fmt.Errorf("failed to decode revision %d: %w", to.Revision, err)
+	}
+
+	diff := &RevisionDiff{
+		Stack:   to.StackName,
+		From:    from.Revision,
+		To:      to.Revision,
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []PlanEntry{},
+		YAML:    diffLines(from.SourceYAML, to.SourceYAML),
+	}
+
+	fromContainers := make(map[string]ContainerConfig, len(fromConfig.Containers))
+	for _, containerConfig := range fromConfig.Containers {
+		fromContainers[containerConfig.Name] = containerConfig
+	}
+
+	for _, containerConfig := range toConfig.Containers {
+		previous, ok := fromContainers[containerConfig.Name]
+		if !ok {
+			diff.Added = append(diff.Added, containerConfig.Name)
+			continue
+		}
+		delete(fromContainers, containerConfig.Name)
+
+		changes, err := diffConfigs(previous, containerConfig)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			diff.Changed = append(diff.Changed, PlanEntry{
+				Name:    containerConfig.Name,
+				Image:   containerConfig.Image,
+				Changes: changes,
+			})
+		}
+	}
+
+	for name := range fromContainers {
+		diff.Removed = append(diff.Removed, name)
+	}
+	sort.Strings(diff.Removed)
+
+	return diff, nil
+}
+
+// diffConfigs lists the YAML fields that differ between two configurations
+// of the same container
+func diffConfigs(from, to ContainerConfig) ([]FieldChange, error) {
+	fromFields, err := configFields(from)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := configFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for key := range fromFields {
+		keys[key] = true
+	}
+	for key := range toFields {
+		keys[key] = true
+	}
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	var changes []FieldChange
+	for _, name := range names {
+		if fromFields[name] != toFields[name] {
+			changes = append(changes, FieldChange{Field: name, Current: fromFields[name], Desired: toFields[name]})
+		}
+	}
+	return changes, nil
+}
+
+// configFields renders each top-level YAML field of a container
+// configuration as a string so configurations can be compared
+func configFields(config ContainerConfig) (map[string]string, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if key == "name" {
+			continue
+		}
+		if scalar, ok := value.(string); ok {
+			fields[key] = scalar
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = string(encoded)
+	}
+	return fields, nil
+}
+
+// diffLines returns a line diff of two texts based on their longest common
+// subsequence of lines. Lines the texts start and end with are kept as they
+// are; the lines in between are compared with a table of maxDiffCells
+// entries at most, and beyond that are all shown as removed and added.
+func diffLines(from, to string) []string {
+	a := strings.Split(strings.TrimRight(from, "\n"), "\n")
+	b := strings.Split(strings.TrimRight(to, "\n"), "\n")
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]string, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		lines = append(lines, " "+line)
+	}
+	lines = append(lines, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, " "+line)
+	}
+	return lines
+}
+
+// diffMiddle diffs the lines between the common prefix and suffix of two
+// texts
+func diffMiddle(a, b []string) []string {
+	lines := []string{}
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			lines = append(lines, "-"+line)
+		}
+		for _, line := range b {
+			lines = append(lines, "+"+line)
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, "+"+b[j])
+	}
+	return lines
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		expected []string
+	}{
+		{
+			name:     "identical",
+			from:     "a\nb\n",
+			to:       "a\nb\n",
+			expected: []string{" a", " b"},
+		},
+		{
+			name:     "changed line",
+			from:     "a\nb\nc\n",
+			to:       "a\nB\nc\n",
+			expected: []string{" a", "-b", "+B", " c"},
+		},
+		{
+			name:     "added and removed lines",
+			from:     "a\nb\nc\nd\n",
+			to:       "a\nc\nd\ne\n",
+			expected: []string{" a", "-b", " c", " d", "+e"},
+		},
+		{
+			name:     "moved line",
+			from:     "a\nb\nc\n",
+			to:       "b\nc\na\n",
+			expected: []string{"-a", " b", " c", "+a"},
+		},
+		{
+			name:     "from empty",
+			from:     "",
+			to:       "a\n",
+			expected: []string{"-", "+a"},
+		},
+		{
+			name:     "trailing newlines ignored",
+			from:     "a\n\n\n",
+			to:       "a",
+			expected: []string{" a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if lines := diffLines(test.from, test.to); !reflect.DeepEqual(lines, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, lines)
+			}
+		})
+	}
+}
+
+func TestDiffLinesBoundsLargeChanges(t *testing.T) {
+	// 2000 lines on each side differ, past maxDiffCells
+	var from, to []string
+	for i := 0; i < 2000; i++ {
+		from = append(from, fmt.Sprintf("from %d", i))
+		to = append(to, fmt.Sprintf("to %d", i))
+	}
+	text := func(middle []string) string {
+		return "head\n" + strings.Join(middle, "\n") + "\ntail\n"
+	}
+
+	lines := diffLines(text(from), text(to))
+	if len(lines) != 4002 {
+		t.Fatalf("expected 4002 lines, got %d", len(lines))
+	}
+	if lines[0] != " head" || lines[len(lines)-1] != " tail" {
+		t.Errorf("expected the common lines to be kept, got %q and %q", lines[0], lines[len(lines)-1])
+	}
+	if lines[1] != "-from 0" || lines[2000] != "-from 1999" || lines[2001] != "+to 0" || lines[4000] != "+to 1999" {
+		t.Errorf("expected every removed line before every added line, got %q, %q, %q and %q", lines[1], lines[2000], lines[2001], lines[4000])
+	}
+}
+
+func TestDiffConfigs(t *testing.T) {
+	base := ContainerConfig{
+		Name:   "web",
+		Image:  "nginx:1.27",
+		Ports:  "8080:80",
+		Env:    map[string]string{"MODE": "production"},
+		Labels: map[string]string{"team": "web"},
+	}
+
+	tests := []struct {
+		name     string
+		change   func(config *ContainerConfig)
+		expected []FieldChange
+	}{
+		{
+			name:   "unchanged",
+			change: func(config *ContainerConfig) {},
+		},
+		{
+			name:     "image",
+			change:   func(config *ContainerConfig) { config.Image = "nginx:1.28" },
+			expected: []FieldChange{{Field: "image", Current: "nginx:1.27", Desired: "nginx:1.28"}},
+		},
+		{
+			name: "environment and ports",
+			change: func(config *ContainerConfig) {
+				config.Env = map[string]string{"MODE": "debug"}
+				config.Ports = "9090:80"
+			},
+			expected: []FieldChange{
+				{Field: "env", Current: `{"MODE":"production"}`, Desired: `{"MODE":"debug"}`},
+				{Field: "ports", Current: "8080:80", Desired: "9090:80"},
+			},
+		},
+		{
+			name:     "field added",
+			change:   func(config *ContainerConfig) { config.Command = "nginx -g daemon" },
+			expected: []FieldChange{{Field: "command", Current: "", Desired: "nginx -g daemon"}},
+		},
+		{
+			name:     "field removed",
+			change:   func(config *ContainerConfig) { config.Labels = nil },
+			expected: []FieldChange{{Field: "labels", Current: `{"team":"web"}`, Desired: ""}},
+		},
+		{
+			name:   "renamed",
+			change: func(config *ContainerConfig) { config.Name = "www" },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			to := base
+			test.change(&to)
+
+			changes, err := diffConfigs(base, to)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, changes)
+			}
+		})
+	}
+}
+
+func TestRollbackToFailedRevision(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, "name: site\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+
+	// Revision 2 failed, leaving revision 1 deployed
+	failedYAML := "name: site\ncontainers:\n  - name: web\n    image: nginx:1.28\n    ports: \"8080:80\"\n"
+	failed := models.StackRevision{
+		StackName:  "site",
+		Revision:   2,
+		SourceYAML: failedYAML,
+		Spec:       "{}",
+		Actor:      "admin",
+		Result:     models.RevisionFailed,
+		Error:      "failed to start container 'web'",
+	}
+	if err := database.GetDB().Create(&failed).Error; err != nil {
+		t.Fatalf("failed to record revision: %v", err)
+	}
+
+	response := s.do(admin, http.MethodPost, "/api/stacks/site/rollback/2", nil)
+	if response.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", response.Code, response.Body.String())
+	}
+	if image := s.container("web").Image; image != "nginx:1.27" {
+		t.Fatalf("expected the rollback to be refused, web runs %s", image)
+	}
+
+	response = s.do(admin, http.MethodPost, "/api/stacks/site/rollback/2?force=true", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected a forced rollback to succeed, got %d: %s", response.Code, response.Body.String())
+	}
+	if image := s.container("web").Image; image != "nginx:1.28" {
+		t.Errorf("expected web to run nginx:1.28 after the forced rollback, got %s", image)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, "name: site\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+	s.apply(admin, "name: site\ncontainers:\n  - name: web\n    image: nginx:1.28\n    ports: \"8080:80\"\n")
+
+	response := s.do(admin, http.MethodPost, "/api/stacks/site/rollback/1", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("rollback returned %d: %s", response.Code, response.Body.String())
+	}
+	if image := s.container("web").Image; image != "nginx:1.27" {
+		t.Errorf("expected web to run nginx:1.27 after the rollback, got %s", image)
+	}
+	info, err := s.engine.ContainerInspect(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("failed to inspect web: %v", err)
+	}
+	if !info.State.Running || info.Config.Image != "nginx:1.27" {
+		t.Errorf("expected the Docker container to run nginx:1.27, got %s (%s)", info.Config.Image, info.State.Status)
+	}
+
+	// The rollback is recorded as a revision of its own
+	var revisions []models.StackRevision
+	database.GetDB().Where("stack_name = ?", "site").Order("revision").Find(&revisions)
+	if len(revisions) != 3 || revisions[2].SourceYAML != revisions[0].SourceYAML {
+		t.Errorf("expected a third revision with the YAML of the first, got %d revisions", len(revisions))
+	}
+
+	if response := s.do(admin, http.MethodPost, "/api/stacks/site/rollback/9", nil); response.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown revision, got %d: %s", response.Code, response.Body.String())
+	}
+}
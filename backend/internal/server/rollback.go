@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"log"
+	"strings"
+	"time"
+)
+
+// backupSuffix is appended to the name of a container that is moved out of
+// the way while its replacement is deployed
+const backupSuffix = "-dockformer-backup"
+
+// ApplyError is returned when applying a stack failed. Every change made
+// before the failure has been undone, and RolledBack lists those changes.
+type ApplyError struct {
+	Err        error
+	RolledBack []string
+}
+
+// Error returns the error that caused the rollback
+func (e *ApplyError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error that caused the rollback
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// backupContainer is a container that was renamed while a stack is applied
+type backupContainer struct {
+	name       string
+	backupName string
+	wasRunning bool
+}
+
+// deployment tracks the Docker resources touched while a stack is applied so
+// they can be restored if a later step fails. Previous containers are kept
+// under a backup name until the whole stack is deployed.
+type deployment struct {
+	created  []string
+	backups  []backupContainer
+	networks []string
+	// waiting are the containers left to start once the apply is committed
+	waiting []waitingContainer
+}
+
+// waitingContainer is a container created by an apply whose dependencies
+// weren't ready yet
+type waitingContainer struct {
+	config      ContainerConfig
+	containerID string
+}
+
+// Helper functions
+
+// backup stops a container and renames it out of the way, freeing its name
+// for the replacement
+func (d *deployment) backup(ctx context.Context, info container.InspectResponse) error {
+	name := strings.TrimPrefix(info.Name, "/")
+	if info.State.Running {
+		if err := dockerClient.ContainerStop(ctx, info.ID, container.StopOptions{}); err != nil {
+			return fmt.Errorf("failed to stop container '%s': %w", name, err)
+		}
+	}
+
+	backupName := fmt.Sprintf("%s%s-%d", name, backupSuffix, time.Now().Unix())
+	if err := dockerClient.ContainerRename(ctx, info.ID, backupName); err != nil {
+		// Leave the container as we found it
+		if info.State.Running {
+			dockerClient.ContainerStart(ctx, info.ID, container.StartOptions{})
+		}
+		return fmt.Errorf("failed to back up container '%s': %w", name, err)
+	}
+
+	d.backups = append(d.backups, backupContainer{
+		name:       name,
+		backupName: backupName,
+		wasRunning: info.State.Running,
+	})
+	return nil
+}
+
+// rollback removes everything the deployment created and restores the
+// containers it replaced, returning a description of each step
+func (d *deployment) rollback(ctx context.Context) []string {
+	rolledBack := []string{}
+
+	// New containers hold the original names, so they go first
+	for i := len(d.created) - 1; i >= 0; i-- {
+		name := d.created[i]
+		err := dockerClient.ContainerRemove(ctx, name, container.RemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		})
+		if err != nil {
+			log.Printf("Rollback: failed to remove container %s: %v", name, err)
+			continue
+		}
+		rolledBack = append(rolledBack, fmt.Sprintf("removed container %s", name))
+	}
+
+	for i := len(d.backups) - 1; i >= 0; i-- {
+		backup := d.backups[i]
+		if err := dockerClient.ContainerRename(ctx, backup.backupName, backup.name); err != nil {
+			log.Printf("Rollback: failed to restore container %s from %s: %v", backup.name, backup.backupName, err)
+			continue
+		}
+		if backup.wasRunning {
+			if err := dockerClient.ContainerStart(ctx, backup.name, container.StartOptions{}); err != nil {
+				log.Printf("Rollback: failed to start restored container %s: %v", backup.name, err)
+			}
+		}
+		rolledBack = append(rolledBack, fmt.Sprintf("restored container %s", backup.name))
+	}
+
+	for i := len(d.networks) - 1; i >= 0; i-- {
+		name := d.networks[i]
+		if err := dockerClient.NetworkRemove(ctx, name); err != nil {
+			log.Printf("Rollback: failed to remove network %s: %v", name, err)
+			continue
+		}
+		rolledBack = append(rolledBack, fmt.Sprintf("removed network %s", name))
+	}
+
+	return rolledBack
+}
+
+// commit removes the backups once the deployment has succeeded
+func (d *deployment) commit(ctx context.Context) {
+	for _, backup := range d.backups {
+		err := dockerClient.ContainerRemove(ctx, backup.backupName, container.RemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		})
+		if err != nil {
+			log.Printf("Failed to remove backup container %s: %v", backup.backupName, err)
+		}
+	}
+}
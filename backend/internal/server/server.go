@@ -2,50 +2,33 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	_ "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 	"github.com/gin-gonic/gin"
 	"github.com/hspgit/DockFormer/internal/database"
 	"github.com/hspgit/DockFormer/internal/models"
-	"gopkg.in/yaml.v3"
 	"io"
 	"log"
-	"mime/multipart"
 	_ "mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
 
-type ContainersConfig struct {
-	Containers []ContainerConfig `yaml:"containers"`
-}
-
-// ContainerConfig represents the YAML configuration for container creation
-type ContainerConfig struct {
-	Name     string            `yaml:"name"`
-	Image    string            `yaml:"image"`
-	Ports    string            `yaml:"ports"`
-	Env      map[string]string `yaml:"env,omitempty"`
-	Volumes  []string          `yaml:"volumes,omitempty"`
-	Command  string            `yaml:"command,omitempty"`
-	Networks []string          `yaml:"networks,omitempty"`
-}
-
-var dockerClient *client.Client
-
-// InitDocker initializes the Docker client
-func InitDocker() error {
-	var err error
-	dockerClient, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	return err
+// UpdateContainerRequest is the body of a request updating a container.
+// Fields left out keep their current value.
+type UpdateContainerRequest struct {
+	Image   *string `json:"image"`
+	Ports   *string `json:"ports"`
+	StackID *uint   `json:"stack_id"`
 }
 
 // StartServer initializes and starts the HTTP server on the specified address
@@ -55,7 +38,24 @@ func StartServer(addr string) {
 	}
 	log.Println("Docker client initialized successfully")
 
+	if err := bootstrapAdmin(); err != nil {
+		log.Fatalf("Failed to create bootstrap admin: %v", err)
+	}
+
+	if err := policies.load(); err != nil {
+		log.Fatalf("Failed to load policies: %v", err)
+	}
+
+	// Keep container state in the database in step with Docker
+	go watchDockerEvents(context.Background())
+	go runPeriodicSync(context.Background())
+	go stats.run(context.Background())
+	if archive := newLogArchive(); archive != nil {
+		go archive.run(context.Background())
+	}
+
 	router := gin.Default()
+	router.Use(metricsMiddleware())
 	router.LoadHTMLGlob("web/templates/*.html")
 	router.Static("/static", "web/static")
 	setupRoutes(router)
@@ -77,26 +77,101 @@ func setupRoutes(router *gin.Engine) {
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
+	router.GET("/login", loginPageHandler)
+	router.POST("/login", loginHandler)
+
+	// Container actions used to be GET links, which other sites could trigger
+	router.GET("/container/:id/start", postOnlyHandler)
+	router.GET("/container/:id/stop", postOnlyHandler)
+	router.GET("/container/:id/restart", postOnlyHandler)
+
+	// Everything else requires a session cookie or an API token, and a role
+	// allowing the route. State-changing requests made with a session cookie
+	// must also carry the session's CSRF token, and are recorded in the
+	// audit log along with denied requests. Viewers may read, operators may also start, stop
+	// and restart, and admins may do everything. Stack grants raise a user's
+	// role for routes acting on that stack or its containers.
+	viewer := requireRole(models.RoleViewer)
+	admin := requireRole(models.RoleAdmin)
+	stackOperator := requireStackRole(models.RoleOperator, stackFromParam)
+	stackAdmin := requireStackRole(models.RoleAdmin, stackFromParam)
+	containerOperator := requireStackRole(models.RoleOperator, stackFromContainer)
+	containerAdmin := requireStackRole(models.RoleAdmin, stackFromContainer)
+
+	authed := router.Group("", hashPayload(), requireAuth(), csrfProtect())
+	authed.POST("/logout", logoutHandler)
+	authed.GET("/metrics", viewer, metricsHandler)
+
+	authed.GET("/", viewer, dashboardHandler)
+	authed.POST("/upload", audit("upload", nil), admin, uploadYamlHandler)
+	authed.POST("/container/:id/start", audit("start", containerTarget), containerOperator, startContainerHandler)
+	authed.POST("/container/:id/stop", audit("stop", containerTarget), containerOperator, stopContainerHandler)
+	authed.POST("/container/:id/restart", audit("restart", containerTarget), containerOperator, restartContainerHandler)
+	authed.GET("/container/:id/logs", viewer, containerLogsHandler)
+	authed.GET("/container/:id/exec", containerAdmin, execPageHandler)
+
+	api := authed.Group("/api")
+	{
+		api.GET("/session", viewer, getSession)
+		api.POST("/validate", viewer, validateHandler)
+		api.POST("/plan", viewer, planHandler)
+		api.POST("/apply", audit("upload", nil), admin, applyHandler)
+		api.POST("/sync", audit("sync", nil), admin, syncHandler)
+		api.GET("/logs/search", viewer, searchLogs)
+		api.GET("/exec-sessions", admin, getExecSessions)
+		api.GET("/stats/stream", viewer, streamStats)
+		api.GET("/audit", admin, getAuditEvents)
+		api.GET("/audit/export", admin, exportAuditEvents)
+		api.GET("/policies", viewer, getPolicies)
+		api.POST("/policies/reload", audit("policy.reload", nil), admin, reloadPolicies)
+
+		users := api.Group("/users", admin)
+		{
+			users.GET("", getUsers)
+			users.POST("", audit("user.create", nil), createUser)
+			users.DELETE("/:id", audit("user.delete", nil), deleteUser)
+			users.PUT("/:id/role", audit("user.role", nil), updateUserRole)
+			users.GET("/:id/grants", getUserGrants)
+			users.PUT("/:id/grants/:stack", audit("grant.update", grantTarget), putUserGrant)
+			users.DELETE("/:id/grants/:stack", audit("grant.delete", grantTarget), deleteUserGrant)
+		}
 
-	router.GET("/", dashboardHandler)
-	router.POST("/upload", uploadYamlHandler)
-	router.GET("/container/:id/start", startContainerHandler)
-	router.GET("/container/:id/stop", stopContainerHandler)
-	router.GET("/container/:id/restart", restartContainerHandler)
-	router.GET("/container/:id/logs", containerLogsHandler)
+		// Every user manages their own tokens
+		tokens := api.Group("/tokens", viewer)
+		{
+			tokens.GET("", getAPITokens)
+			tokens.POST("", audit("token.create", nil), createAPIToken)
+			tokens.DELETE("/:id", audit("token.delete", nil), deleteAPIToken)
+		}
+
+		stacks := api.Group("/stacks")
+		{
+			stacks.GET("", viewer, getStacks)
+			stacks.GET("/:name", viewer, getStack)
+			stacks.DELETE("/:name", audit("delete", stackTarget), stackAdmin, deleteStack)
+			stacks.POST("/:name/start", audit("start", stackTarget), stackOperator, apiStartStack)
+			stacks.POST("/:name/stop", audit("stop", stackTarget), stackOperator, apiStopStack)
+			stacks.POST("/:name/restart", audit("restart", stackTarget), stackOperator, apiRestartStack)
+			stacks.GET("/:name/revisions", viewer, getStackRevisions)
+			stacks.GET("/:name/revisions/:rev", viewer, getStackRevision)
+			stacks.GET("/:name/diff", viewer, diffStackRevisions)
+			stacks.POST("/:name/rollback/:rev", audit("rollback", stackTarget), stackAdmin, rollbackStack)
+		}
 
-	api := router.Group("/api")
-	{
 		containers := api.Group("/containers")
 		{
-			containers.GET("", getContainers)
-			containers.GET("/:id", getContainer)
-			containers.POST("", createContainer)
-			containers.PUT("/:id", updateContainer)
-			containers.DELETE("/:id", deleteContainer)
-			containers.POST("/:id/start", apiStartContainer)
-			containers.POST("/:id/stop", apiStopContainer)
-			containers.POST("/:id/restart", apiRestartContainer)
+			containers.GET("", viewer, getContainers)
+			containers.GET("/:id", viewer, getContainer)
+			containers.POST("", audit("create", nil), admin, createContainer)
+			containers.PUT("/:id", audit("update", containerTarget), containerAdmin, updateContainer)
+			containers.DELETE("/:id", audit("delete", containerTarget), containerAdmin, deleteContainer)
+			containers.POST("/:id/start", audit("start", containerTarget), containerOperator, apiStartContainer)
+			containers.POST("/:id/stop", audit("stop", containerTarget), containerOperator, apiStopContainer)
+			containers.POST("/:id/restart", audit("restart", containerTarget), containerOperator, apiRestartContainer)
+			containers.GET("/:id/logs", viewer, getContainerLogs)
+			containers.GET("/:id/logs/stream", viewer, streamContainerLogs)
+			containers.GET("/:id/exec", audit("exec", containerTarget), containerAdmin, execContainer)
+			containers.GET("/:id/stats", viewer, getContainerStats)
 		}
 	}
 }
@@ -105,7 +180,8 @@ func setupRoutes(router *gin.Engine) {
 func dashboardHandler(c *gin.Context) {
 	var containerList []models.Container
 
-	// Get containers from the database
+	// Get containers from the database, their state is kept current by the
+	// Docker event watcher
 	result := database.GetDB().Find(&containerList)
 	if result.Error != nil {
 		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
@@ -114,100 +190,52 @@ func dashboardHandler(c *gin.Context) {
 		return
 	}
 
-	// Update container statuses from Docker
-	ctx := context.Background()
-	for i := range containerList {
-		if dockerClient != nil {
-			containerInfo, err := dockerClient.ContainerInspect(ctx, containerList[i].Name)
-			if err == nil {
-				containerList[i].Status = models.ContainerStatus(containerInfo.State.Status)
-			}
-		}
+	// Get stacks with their containers for the stack overview
+	var stackList []models.Stack
+	if err := database.GetDB().Preload("Containers").Order("name").Find(&stackList).Error; err != nil {
+		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
 	c.HTML(http.StatusOK, "index.html", gin.H{
+		"user":       currentUser(c),
+		"csrfToken":  csrfToken(c),
+		"stacks":     stackList,
 		"containers": containerList,
 	})
 }
 
 func uploadYamlHandler(c *gin.Context) {
-	// Get the file from the request
-	file, err := c.FormFile("yamlFile")
+	// Read the uploaded YAML file
+	yamlData, defaultName, err := readYamlRequest(c)
 	if err != nil {
 		c.HTML(http.StatusBadRequest, "error.html", gin.H{
-			"error": "Failed to get file: " + err.Error(),
+			"error": err.Error(),
 		})
 		return
 	}
 
-	// Validate file is a YAML file
-	ext := filepath.Ext(file.Filename)
-	if ext != ".yaml" && ext != ".yml" {
-		c.HTML(http.StatusBadRequest, "error.html", gin.H{
-			"error": "File must be a YAML file (.yaml or .yml)",
-		})
-		return
-	}
-
-	// Open the uploaded file
-	openFile, err := file.Open()
+	// Parse and validate the YAML before touching Docker
+	config, err := parseContainersConfig(yamlData)
 	if err != nil {
-		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
-			"error": "Failed to open file: " + err.Error(),
-		})
+		c.HTML(http.StatusBadRequest, "error.html", configErrorBody(err))
 		return
 	}
-	defer func(openFile multipart.File) {
-		err := openFile.Close()
-		if err != nil {
-			log.Printf("Failed to close file: %v", err)
-		}
-	}(openFile)
+	stackName := config.stackName(defaultName)
+	setAuditTarget(c, "", stackName)
 
-	// Read the YAML content
-	yamlData, err := io.ReadAll(openFile)
+	// Apply the file as a stack, only recreating containers that changed
+	_, err = applyStack(stackName, yamlData, config, applyOptions{
+		Prune: pruneRequested(c),
+		Actor: requestActor(c),
+	})
 	if err != nil {
-		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
-			"error": "Failed to read file: " + err.Error(),
-		})
+		c.HTML(applyErrorStatus(err), "error.html", applyErrorBody(err))
 		return
 	}
 
-	// Parse the YAML into ContainersConfig
-	var config ContainersConfig
-	if err := yaml.Unmarshal(yamlData, &config); err != nil {
-		c.HTML(http.StatusBadRequest, "error.html", gin.H{
-			"error": "Failed to parse YAML: " + err.Error(),
-		})
-		return
-	}
-
-	// Iterate over each container configuration and create containers
-	for _, containerConfig := range config.Containers {
-		containerID, err := createDockerContainer(containerConfig)
-		if err != nil {
-			c.HTML(http.StatusInternalServerError, "error.html", gin.H{
-				"error": fmt.Sprintf("Failed to create container '%s': %v", containerConfig.Name, err),
-			})
-			return
-		}
-
-		// Save to the database
-		containerObj := models.Container{
-			Name:        containerConfig.Name,
-			Image:       containerConfig.Image,
-			Ports:       containerConfig.Ports,
-			ContainerID: containerID,
-			Status:      "created",
-		}
-		if result := database.GetDB().Create(&containerObj); result.Error != nil {
-			c.HTML(http.StatusInternalServerError, "error.html", gin.H{
-				"error": fmt.Sprintf("Failed to save container '%s' to database: %v", containerConfig.Name, result.Error),
-			})
-			return
-		}
-	}
-
 	// Redirect back to dashboard
 	c.Redirect(http.StatusSeeOther, "/")
 }
@@ -307,39 +335,9 @@ func containerLogsHandler(c *gin.Context) {
 		return
 	}
 
-	// Get container logs from Docker
-	ctx := context.Background()
-	options := container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Tail:       "100",
-	}
-	logReader, err := dockerClient.ContainerLogs(ctx, containerObj.Name, options)
-	if err != nil {
-		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
-			"error": "Failed to get container logs: " + err.Error(),
-		})
-		return
-	}
-	defer func(logReader io.ReadCloser) {
-		err := logReader.Close()
-		if err != nil {
-			log.Printf("Failed to close log reader: %v", err)
-		}
-	}(logReader)
-
-	// Read logs
-	logs, err := io.ReadAll(logReader)
-	if err != nil {
-		c.HTML(http.StatusInternalServerError, "error.html", gin.H{
-			"error": "Failed to read container logs: " + err.Error(),
-		})
-		return
-	}
-
+	// Logs are streamed by the page from the logs API
 	c.HTML(http.StatusOK, "logs.html", gin.H{
 		"container": containerObj,
-		"logs":      string(logs),
 	})
 }
 
@@ -347,23 +345,19 @@ func containerLogsHandler(c *gin.Context) {
 func getContainers(c *gin.Context) {
 	var containerList []models.Container
 
-	result := database.GetDB().Find(&containerList)
+	query := database.GetDB()
+
+	// Filter by health state, e.g. ?health=unhealthy
+	if health := c.Query("health"); health != "" {
+		query = query.Where("health = ?", health)
+	}
+
+	result := query.Find(&containerList)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
 	}
 
-	// Update container statuses from Docker
-	if dockerClient != nil {
-		ctx := context.Background()
-		for i := range containerList {
-			containerInfo, err := dockerClient.ContainerInspect(ctx, containerList[i].Name)
-			if err == nil {
-				containerList[i].Status = models.ContainerStatus(containerInfo.State.Status)
-			}
-		}
-	}
-
 	c.JSON(http.StatusOK, containerList)
 }
 
@@ -377,15 +371,6 @@ func getContainer(c *gin.Context) {
 		return
 	}
 
-	// Get latest status from Docker
-	if dockerClient != nil {
-		ctx := context.Background()
-		containerInfo, err := dockerClient.ContainerInspect(ctx, containerObj.Name)
-		if err == nil {
-			containerObj.Status = models.ContainerStatus(containerInfo.State.Status)
-		}
-	}
-
 	c.JSON(http.StatusOK, containerObj)
 }
 
@@ -403,6 +388,7 @@ func createContainer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and image are required"})
 		return
 	}
+	setAuditTarget(c, containerObj.Name, "")
 
 	// Create Docker container
 	config := ContainerConfig{
@@ -410,16 +396,40 @@ func createContainer(c *gin.Context) {
 		Image: containerObj.Image,
 		Ports: containerObj.Ports,
 	}
+	if err := policies.check([]ContainerConfig{config}); err != nil {
+		c.JSON(applyErrorStatus(err), applyErrorBody(err))
+		return
+	}
 
-	containerID, err := createDockerContainer(config)
+	// Never take over or replace an existing container, whether it belongs
+	// to a stack or not. Applies hold the lock while they create containers.
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+	ctx := context.Background()
+	if err := checkNameConflicts(ctx, "", []ContainerConfig{config}); err != nil {
+		c.JSON(applyErrorStatus(err), applyErrorBody(err))
+		return
+	}
+	exists, err := containerNameTaken(ctx, config.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Container '%s' already exists", config.Name)})
+		return
+	}
+
+	containerID, err := createDockerContainer(config, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Docker container: " + err.Error()})
 		return
 	}
 
-	// Set initial status and ContainerID
+	// Set initial status, ContainerID and spec hash
 	containerObj.Status = "created"
 	containerObj.ContainerID = containerID
+	containerObj.SpecHash = specHash(config)
 
 	// Save to the database
 	result := database.GetDB().Create(&containerObj)
@@ -442,17 +452,79 @@ func updateContainer(c *gin.Context) {
 		return
 	}
 
-	// Save current values to update only provided fields
-	currentContainer := containerObj
-
-	// Bind new data
-	if err := c.ShouldBindJSON(&containerObj); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Only the fields of the request can change, the name and Docker ID
+	// identify the container and are rejected as unknown fields
+	var request UpdateContainerRequest
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid update, only image, ports and stack_id can be changed: " + err.Error()})
 		return
 	}
 
-	// Ensure ID remains the same
-	containerObj.ID = currentContainer.ID
+	// Moving a container needs the admin role on the stack it moves to as
+	// well as on the one it leaves
+	if request.StackID != nil && (containerObj.StackID == nil || *request.StackID != *containerObj.StackID) {
+		var stack models.Stack
+		if err := database.GetDB().First(&stack, *request.StackID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Stack not found"})
+			return
+		}
+
+		role, err := effectiveRole(currentUser(c), stack.Name)
+		if err != nil {
+			log.Printf("Failed to load grants of %s: %v", currentUser(c).Username, err)
+			abortWithError(c, http.StatusInternalServerError, "Failed to check permissions")
+			return
+		}
+		if !role.Allows(models.RoleAdmin) {
+			denyRequest(c, models.RoleAdmin, stack.Name, fmt.Sprintf("has role %s", role))
+			return
+		}
+		containerObj.StackID = &stack.ID
+	}
+
+	config := ContainerConfig{
+		Name:  containerObj.Name,
+		Image: containerObj.Image,
+		Ports: containerObj.Ports,
+	}
+	if request.Image != nil {
+		config.Image = *request.Image
+	}
+	if request.Ports != nil {
+		config.Ports = *request.Ports
+	}
+	if config.Image != containerObj.Image || config.Ports != containerObj.Ports {
+		// The image and ports of stack containers come from the stack file,
+		// and recreating an adopted container from them would lose the rest
+		// of its configuration
+		if containerObj.StackID != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Image and ports of stack containers can only be changed by applying the stack file"})
+			return
+		}
+		if containerObj.SpecHash == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Container '%s' wasn't created by DockFormer, its image and ports can't be changed", containerObj.Name)})
+			return
+		}
+		if config.Image == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Image is required"})
+			return
+		}
+		if err := policies.check([]ContainerConfig{config}); err != nil {
+			c.JSON(applyErrorStatus(err), applyErrorBody(err))
+			return
+		}
+
+		applyMutex.Lock()
+		defer applyMutex.Unlock()
+		if err := recreateContainer(context.Background(), &containerObj, config); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recreate container: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, containerObj)
+		return
+	}
 
 	// Update container in database
 	result := database.GetDB().Save(&containerObj)
@@ -564,8 +636,83 @@ func apiRestartContainer(c *gin.Context) {
 
 // Helper functions
 
-// createDockerContainer creates a container in Docker based on the provided configuration
-func createDockerContainer(config ContainerConfig) (string, error) {
+// containerNameTaken reports whether a container of that name exists in the
+// database or in Docker
+func containerNameTaken(ctx context.Context, name string) (bool, error) {
+	var count int64
+	if err := database.GetDB().Model(&models.Container{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	_, err := dockerClient.ContainerInspect(ctx, name)
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container '%s': %w", name, err)
+	}
+	return true, nil
+}
+
+// recreateContainer replaces the Docker container of a standalone container
+// with one created from config, then saves the row. The previous container
+// is kept as a backup and restored if any step fails.
+func recreateContainer(ctx context.Context, containerObj *models.Container, config ContainerConfig) error {
+	deploy := &deployment{}
+	fail := func(err error) error {
+		deploy.rollback(ctx)
+		return err
+	}
+
+	info, err := dockerClient.ContainerInspect(ctx, containerObj.Name)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to inspect container '%s': %w", containerObj.Name, err)
+	}
+	wasRunning := err == nil && info.State.Running
+	if err == nil {
+		if err := deploy.backup(ctx, info); err != nil {
+			return err
+		}
+	}
+
+	containerID, err := createDockerContainer(config, "")
+	if containerID != "" {
+		deploy.created = append(deploy.created, config.Name)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	// Bring the container back to the state it was in
+	status := models.StatusCreated
+	if wasRunning {
+		if err := dockerClient.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+			return fail(fmt.Errorf("failed to start container '%s': %w", config.Name, err))
+		}
+		status = models.StatusRunning
+	}
+
+	updated := *containerObj
+	updated.Image = config.Image
+	updated.Ports = config.Ports
+	updated.ContainerID = containerID
+	updated.SpecHash = specHash(config)
+	updated.Status = status
+	if err := database.GetDB().Save(&updated).Error; err != nil {
+		return fail(err)
+	}
+
+	deploy.commit(ctx)
+	*containerObj = updated
+	return nil
+}
+
+// createDockerContainer creates a container in Docker based on the provided configuration.
+// When stackName is set the container is labelled as a member of that stack.
+func createDockerContainer(config ContainerConfig, stackName string) (string, error) {
 	ctx := context.Background()
 
 	// Pull image if it doesn't exist
@@ -589,37 +736,9 @@ func createDockerContainer(config ContainerConfig) (string, error) {
 	}
 
 	// Parse port mappings
-	portBindings := nat.PortMap{}
-	exposedPorts := nat.PortSet{}
-
-	if config.Ports != "" {
-		for _, portStr := range strings.Split(config.Ports, ",") {
-			portStr = strings.TrimSpace(portStr)
-			if portStr == "" {
-				continue
-			}
-
-			parts := strings.Split(portStr, ":")
-			if len(parts) != 2 {
-				return "", errors.New("invalid port mapping format")
-			}
-
-			hostPort := parts[0]
-			containerPort := parts[1]
-
-			// Check if container port includes protocol
-			if !strings.Contains(containerPort, "/") {
-				containerPort = containerPort + "/tcp"
-			}
-
-			natPort, err := nat.NewPort(strings.Split(containerPort, "/")[1], strings.Split(containerPort, "/")[0])
-			if err != nil {
-				return "", err
-			}
-
-			exposedPorts[natPort] = struct{}{}
-			portBindings[natPort] = []nat.PortBinding{{HostPort: hostPort}}
-		}
+	exposedPorts, portBindings, err := parsePorts(config.Ports)
+	if err != nil {
+		return "", err
 	}
 
 	// Prepare environment variables
@@ -647,23 +766,58 @@ func createDockerContainer(config ContainerConfig) (string, error) {
 		ExposedPorts: exposedPorts,
 	}
 
+	// User labels can't use the dockformer. prefix, so they never clash
+	containerConfig.Labels = map[string]string{labelSpecHash: specHash(config)}
+	for key, value := range config.Labels {
+		containerConfig.Labels[key] = value
+	}
+	if stackName != "" {
+		containerConfig.Labels[labelStack] = stackName
+	}
+
 	if config.Command != "" {
 		containerConfig.Cmd = strings.Split(config.Command, " ")
 	}
 
+	if config.Healthcheck != nil {
+		healthConfig, err := config.Healthcheck.toDocker()
+		if err != nil {
+			return "", err
+		}
+		containerConfig.Healthcheck = healthConfig
+	}
+
+	restartPolicy, resources, err := config.resources()
+	if err != nil {
+		return "", err
+	}
+
 	hostConfig := &container.HostConfig{
-		PortBindings: portBindings,
+		PortBindings:  portBindings,
+		RestartPolicy: restartPolicy,
+		Resources:     resources,
 	}
 
 	if len(config.Volumes) > 0 {
 		hostConfig.Binds = config.Volumes
 	}
 
+	// Attach to the first network at creation, the rest are connected after
+	var networkingConfig *network.NetworkingConfig
+	if len(config.Networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(config.Networks[0])
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				config.Networks[0]: {Aliases: []string{config.Name}},
+			},
+		}
+	}
+
 	response, err := dockerClient.ContainerCreate(
 		ctx,
 		containerConfig,
 		hostConfig,
-		nil,
+		networkingConfig,
 		nil,
 		config.Name,
 	)
@@ -671,71 +825,50 @@ func createDockerContainer(config ContainerConfig) (string, error) {
 		return "", err
 	}
 
+	if err := connectNetworks(response.ID, config); err != nil {
+		return response.ID, err
+	}
+
 	return response.ID, nil
 }
 
-// syncContainersWithDocker synchronizes the database with existing Docker containers
-func syncContainersWithDocker() error {
-	ctx := context.Background()
+// parsePorts parses a comma separated list of "host:container[/proto]" port
+// mappings into the exposed ports and bindings Docker expects
+func parsePorts(ports string) (nat.PortSet, nat.PortMap, error) {
+	portBindings := nat.PortMap{}
+	exposedPorts := nat.PortSet{}
 
-	// Get all containers from Docker
-	containerList, err := dockerClient.ContainerList(ctx, container.ListOptions{All: true})
-	if err != nil {
-		return err
+	if ports == "" {
+		return exposedPorts, portBindings, nil
 	}
 
-	// Get all containers from database
-	var dbContainers []models.Container
-	if err := database.GetDB().Find(&dbContainers).Error; err != nil {
-		return err
-	}
+	for _, portStr := range strings.Split(ports, ",") {
+		portStr = strings.TrimSpace(portStr)
+		if portStr == "" {
+			continue
+		}
 
-	// Map for quick lookup of db containers
-	dbContainerMap := make(map[string]models.Container)
-	for _, c := range dbContainers {
-		dbContainerMap[c.Name] = c
-	}
+		parts := strings.Split(portStr, ":")
+		if len(parts) != 2 {
+			return nil, nil, errors.New("invalid port mapping format")
+		}
 
-	// Update or create entries
-	for _, c := range containerList {
-		// Use the container name without the leading slash
-		name := strings.TrimPrefix(c.Names[0], "/")
+		hostPort := parts[0]
+		containerPort := parts[1]
 
-		// Skip system containers
-		if strings.HasPrefix(name, "k8s_") || name == "POD" {
-			continue
+		// Check if container port includes protocol
+		if !strings.Contains(containerPort, "/") {
+			containerPort = containerPort + "/tcp"
 		}
 
-		ports := ""
-		if len(c.Ports) > 0 {
-			var portStrings []string
-			for _, p := range c.Ports {
-				if p.PublicPort != 0 {
-					portStrings = append(portStrings,
-						fmt.Sprintf("%d:%d", p.PublicPort, p.PrivatePort))
-				}
-			}
-			ports = strings.Join(portStrings, ",")
+		natPort, err := nat.NewPort(strings.Split(containerPort, "/")[1], strings.Split(containerPort, "/")[0])
+		if err != nil {
+			return nil, nil, err
 		}
 
-		if dbContainer, exists := dbContainerMap[name]; exists {
-			// Update existing container
-			dbContainer.Status = models.ContainerStatus(c.State)
-			dbContainer.Image = c.Image
-			dbContainer.Ports = ports
-			database.GetDB().Save(&dbContainer)
-			delete(dbContainerMap, name)
-		} else {
-			// Create new container entry
-			newContainer := models.Container{
-				Name:   name,
-				Image:  c.Image,
-				Status: models.ContainerStatus(c.State),
-				Ports:  ports,
-			}
-			database.GetDB().Create(&newContainer)
-		}
+		exposedPorts[natPort] = struct{}{}
+		portBindings[natPort] = []nat.PortBinding{{HostPort: hostPort}}
 	}
 
-	return nil
+	return exposedPorts, portBindings, nil
 }
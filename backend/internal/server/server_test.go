@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateContainer(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, "name: alpha\ncontainers:\n  - name: web-a\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+	s.apply(admin, "name: beta\ncontainers:\n  - name: web-b\n    image: nginx:1.27\n    ports: \"8081:80\"\n")
+
+	var beta models.Stack
+	if err := database.GetDB().Where("name = ?", "beta").First(&beta).Error; err != nil {
+		t.Fatalf("failed to load stack beta: %v", err)
+	}
+
+	// dev administers alpha only
+	dev := s.user("dev", models.RoleViewer)
+	s.grant("dev", "alpha", models.RoleAdmin)
+
+	webA := s.container("web-a")
+	target := fmt.Sprintf("/api/containers/%d", webA.ID)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"move to a stack without a grant", fmt.Sprintf(`{"stack_id": %d}`, beta.ID), http.StatusForbidden},
+		{"rename", `{"name": "web-b"}`, http.StatusBadRequest},
+		{"change the Docker ID", `{"ContainerID": "abc"}`, http.StatusBadRequest},
+		{"move to a missing stack", `{"stack_id": 9999}`, http.StatusBadRequest},
+		{"invalid JSON", `{"image": `, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := s.do(dev, http.MethodPut, target, test.body)
+			if response.Code != test.status {
+				t.Fatalf("expected %d, got %d: %s", test.status, response.Code, response.Body.String())
+			}
+
+			unchanged := s.container("web-a")
+			if unchanged.Name != webA.Name || *unchanged.StackID != *webA.StackID || unchanged.ContainerID != webA.ContainerID {
+				t.Errorf("container changed to %+v", unchanged)
+			}
+		})
+	}
+
+	t.Run("update image and ports of a stack container", func(t *testing.T) {
+		response := s.do(dev, http.MethodPut, target, `{"image": "nginx:1.28", "ports": "9090:80"}`)
+		if response.Code != http.StatusConflict {
+			t.Fatalf("expected 409, got %d: %s", response.Code, response.Body.String())
+		}
+		unchanged := s.container("web-a")
+		if unchanged.Image != webA.Image || unchanged.Ports != webA.Ports || unchanged.SpecHash != webA.SpecHash {
+			t.Errorf("container changed to %+v", unchanged)
+		}
+	})
+
+	t.Run("move with a grant on both stacks", func(t *testing.T) {
+		s.grant("dev", "beta", models.RoleAdmin)
+		response := s.do(dev, http.MethodPut, target, fmt.Sprintf(`{"stack_id": %d}`, beta.ID))
+		if response.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", response.Code, response.Body.String())
+		}
+		if moved := s.container("web-a"); *moved.StackID != beta.ID {
+			t.Errorf("expected stack %d, got %d", beta.ID, *moved.StackID)
+		}
+	})
+}
+
+func TestUpdateStandaloneContainer(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	response := s.do(admin, http.MethodPost, "/api/containers", map[string]string{"Name": "web", "Image": "nginx:1.27", "Ports": "8080:80"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", response.Code, response.Body.String())
+	}
+	original := s.container("web")
+	target := fmt.Sprintf("/api/containers/%d", original.ID)
+
+	policy := Policy{Name: "nginx only", AllowedImages: []string{"docker.io/library/nginx:*"}}
+	if err := policy.compile(); err != nil {
+		t.Fatalf("failed to compile policy: %v", err)
+	}
+	policies.policies = []Policy{policy}
+	t.Cleanup(func() { policies.policies = nil })
+
+	response = s.do(admin, http.MethodPut, target, `{"image": "redis:7"}`)
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", response.Code, response.Body.String())
+	}
+	if unchanged := s.container("web"); unchanged.Image != original.Image || unchanged.ContainerID != original.ContainerID {
+		t.Errorf("container changed to %+v", unchanged)
+	}
+
+	// The Docker container is recreated from the new image and ports
+	response = s.do(admin, http.MethodPut, target, `{"image": "nginx:1.28", "ports": "9090:80"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", response.Code, response.Body.String())
+	}
+	updated := s.container("web")
+	expected := specHash(ContainerConfig{Name: "web", Image: "nginx:1.28", Ports: "9090:80"})
+	if updated.Image != "nginx:1.28" || updated.Ports != "9090:80" || updated.SpecHash != expected || updated.ContainerID == original.ContainerID {
+		t.Errorf("unexpected container %+v", updated)
+	}
+	info, err := s.engine.ContainerInspect(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("failed to inspect web: %v", err)
+	}
+	if info.ID != updated.ContainerID || info.Config.Image != "nginx:1.28" || info.Config.Labels[labelSpecHash] != expected {
+		t.Errorf("expected the Docker container to be recreated, got %s from %s labelled %v", info.ID, info.Config.Image, info.Config.Labels)
+	}
+	if _, err := s.engine.ContainerInspect(context.Background(), original.ContainerID); err == nil {
+		t.Error("expected the previous container to be removed")
+	}
+
+	// Adopted containers would lose the rest of their configuration
+	adopted := models.Container{Name: "legacy", Image: "nginx:1.27", Status: models.StatusRunning}
+	if err := database.GetDB().Create(&adopted).Error; err != nil {
+		t.Fatalf("failed to create container: %v", err)
+	}
+	response = s.do(admin, http.MethodPut, fmt.Sprintf("/api/containers/%d", adopted.ID), `{"image": "nginx:1.28"}`)
+	if response.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		name     string
+		ports    string
+		expected map[string]string
+		valid    bool
+	}{
+		{"none", "", map[string]string{}, true},
+		{"single", "8080:80", map[string]string{"80/tcp": "8080"}, true},
+		{"protocol and spaces", " 8080:80 , 5353:53/udp,", map[string]string{"80/tcp": "8080", "53/udp": "5353"}, true},
+		{"container port only", "80", nil, false},
+		{"host address", "127.0.0.1:8080:80", nil, false},
+		{"non-numeric container port", "8080:http", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exposed, bindings, err := parsePorts(test.ports)
+			if (err == nil) != test.valid {
+				t.Fatalf("parsePorts(%q) returned %v, expected valid %v", test.ports, err, test.valid)
+			}
+			if !test.valid {
+				return
+			}
+
+			found := make(map[string]string)
+			for port, binding := range bindings {
+				if _, ok := exposed[port]; !ok {
+					t.Errorf("port %s is bound but not exposed", port)
+				}
+				found[string(port)] = binding[0].HostPort
+			}
+			if len(exposed) != len(bindings) || !reflect.DeepEqual(found, test.expected) {
+				t.Errorf("expected bindings %v, got %v with %d exposed ports", test.expected, found, len(exposed))
+			}
+		})
+	}
+}
+
+func TestContainerActions(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, "name: site\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+	target := fmt.Sprintf("/api/containers/%d", s.container("web").ID)
+
+	tests := []struct {
+		action  string
+		running bool
+		status  models.ContainerStatus
+	}{
+		{"stop", false, models.StatusStopped},
+		{"start", true, models.StatusRunning},
+		{"restart", true, models.StatusRunning},
+	}
+	for _, test := range tests {
+		t.Run(test.action, func(t *testing.T) {
+			response := s.do(admin, http.MethodPost, target+"/"+test.action, nil)
+			if response.Code != http.StatusOK {
+				t.Fatalf("%s returned %d: %s", test.action, response.Code, response.Body.String())
+			}
+			if s.running("web") != test.running {
+				t.Errorf("expected web running to be %v after %s", test.running, test.action)
+			}
+			if status := s.container("web").Status; status != test.status {
+				t.Errorf("expected web to be recorded as %s, got %s", test.status, status)
+			}
+		})
+	}
+
+	t.Run("unknown container", func(t *testing.T) {
+		if response := s.do(admin, http.MethodPost, "/api/containers/999/start", nil); response.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d: %s", response.Code, response.Body.String())
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		response := s.do(admin, http.MethodDelete, target, nil)
+		if response.Code != http.StatusOK {
+			t.Fatalf("delete returned %d: %s", response.Code, response.Body.String())
+		}
+		if _, err := s.engine.ContainerInspect(context.Background(), "web"); err == nil {
+			t.Error("expected the Docker container to be removed")
+		}
+		var count int64
+		database.GetDB().Model(&models.Container{}).Where("name = ?", "web").Count(&count)
+		if count != 0 {
+			t.Error("expected the container row to be deleted")
+		}
+	})
+}
+
+func TestGetContainerLogs(t *testing.T) {
+	s := newTestServer(t)
+	viewer := s.user("viewer", models.RoleViewer)
+	s.apply(s.user("admin", models.RoleAdmin), "name: site\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+	target := fmt.Sprintf("/api/containers/%d/logs", s.container("web").ID)
+
+	response := s.do(viewer, http.MethodGet, target, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("logs returned %d: %s", response.Code, response.Body.String())
+	}
+	var records []LogRecord
+	decode(t, response, &records)
+	var texts []string
+	for _, record := range records {
+		if record.Stream != StreamStdout || record.Timestamp.IsZero() {
+			t.Errorf("expected a timestamped stdout record, got %+v", record)
+		}
+		texts = append(texts, record.Text)
+	}
+	expected := []string{"Starting nginx:1.27 as web", "Listening on 80/tcp"}
+	if !reflect.DeepEqual(texts, expected) {
+		t.Errorf("expected %q, got %q", expected, texts)
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		count int
+	}{
+		{"tail=1", http.StatusOK, 1},
+		{"stream=stderr", http.StatusOK, 0},
+		{"timestamps=false", http.StatusOK, 2},
+		{"tail=-1", http.StatusBadRequest, 0},
+		{"timestamps=sometimes", http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			response := s.do(viewer, http.MethodGet, target+"?"+test.query, nil)
+			if response.Code != test.code {
+				t.Fatalf("expected %d, got %d: %s", test.code, response.Code, response.Body.String())
+			}
+			if test.code != http.StatusOK {
+				return
+			}
+			var records []map[string]interface{}
+			decode(t, response, &records)
+			if len(records) != test.count {
+				t.Errorf("expected %d records, got %d", test.count, len(records))
+			}
+			for _, record := range records {
+				if _, ok := record["timestamp"]; ok == (test.query == "timestamps=false") {
+					t.Errorf("expected timestamps only when asked for, got %v", record)
+				}
+			}
+			if test.query == "timestamps=false" && records[0]["text"] != "Starting nginx:1.27 as web" {
+				t.Errorf("expected the line as written, got %v", records[0]["text"])
+			}
+		})
+	}
+}
+
+func TestCreateContainerRefusesTakenNames(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, "name: site\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+	original := s.container("web")
+
+	response := s.do(admin, http.MethodPost, "/api/containers", map[string]string{"Name": "cache", "Image": "redis:7", "Ports": ""})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", response.Code, response.Body.String())
+	}
+
+	tests := []struct {
+		name      string
+		container string
+	}{
+		{"container of a stack", "web"},
+		{"standalone container", "cache"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := s.do(admin, http.MethodPost, "/api/containers", map[string]string{"Name": test.container, "Image": "nginx:1.28", "Ports": ""})
+			if response.Code != http.StatusConflict {
+				t.Fatalf("expected 409, got %d: %s", response.Code, response.Body.String())
+			}
+			var count int64
+			database.GetDB().Model(&models.Container{}).Where("name = ?", test.container).Count(&count)
+			if count != 1 {
+				t.Errorf("expected a single row for %s, got %d", test.container, count)
+			}
+		})
+	}
+
+	info, err := s.engine.ContainerInspect(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("expected web to be left alone: %v", err)
+	}
+	if info.ID != original.ContainerID || info.Config.Labels[labelStack] != "site" {
+		t.Errorf("expected the container of site to be kept, got %s labelled %v", info.ID, info.Config.Labels)
+	}
+}
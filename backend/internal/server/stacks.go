@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Docker labels DockFormer puts on the containers it creates
+const (
+	// labelStack records which stack a container belongs to
+	labelStack = "dockformer.stack"
+	// labelSpecHash records the hash of the ContainerConfig it was created from
+	labelSpecHash = "dockformer.spec-hash"
+)
+
+// stackActions holds the names of the stacks being started in the
+// background, by a start or restart of the stack or after an apply
+var stackActions = struct {
+	sync.Mutex
+	running map[string]bool
+}{running: make(map[string]bool)}
+
+// API handlers
+func getStacks(c *gin.Context) {
+	var stackList []models.Stack
+
+	result := database.GetDB().Preload("Containers").Order("name").Find(&stackList)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, stackList)
+}
+
+func getStack(c *gin.Context) {
+	stack, err := findStack(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stack not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stack)
+}
+
+func deleteStack(c *gin.Context) {
+	stack, err := findStack(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stack not found"})
+		return
+	}
+
+	// Remove every Docker container in the stack
+	ctx := context.Background()
+	for _, containerObj := range stack.Containers {
+		if err := dockerClient.ContainerRemove(ctx, containerObj.Name, container.RemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		}); err != nil {
+			log.Printf("Error removing Docker container %s: %v", containerObj.Name, err)
+		}
+	}
+	removeStackNetworks(stack.Name)
+
+	// Remove the containers and the stack from the database
+	err = database.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("stack_id = ?", stack.ID).Delete(&models.Container{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(stack).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stack deleted successfully"})
+}
+
+func apiStartStack(c *gin.Context) {
+	stack, err := findStack(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stack not found"})
+		return
+	}
+
+	// Waiting for dependencies can outlast the request, so the stack is
+	// started in the background
+	if !runStackAction(stack.Name, "start", func() error { return startStack(stack) }) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Stack is already being started"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Stack is starting", "status": "starting"})
+}
+
+func apiStopStack(c *gin.Context) {
+	stack, err := findStack(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stack not found"})
+		return
+	}
+
+	if err := stopStack(stack); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop stack: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stack stopped successfully"})
+}
+
+func apiRestartStack(c *gin.Context) {
+	stack, err := findStack(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stack not found"})
+		return
+	}
+
+	// Stop everything first so the stack comes back up in order. Both run
+	// in the background, so a stack being started is never stopped halfway.
+	restart := func() error {
+		if err := stopStack(stack); err != nil {
+			return err
+		}
+		return startStack(stack)
+	}
+	if !runStackAction(stack.Name, "restart", restart) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Stack is already being started"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Stack is restarting", "status": "starting"})
+}
+
+// Helper functions
+
+// findStack loads a stack by name together with its containers
+func findStack(name string) (*models.Stack, error) {
+	var stack models.Stack
+	err := database.GetDB().
+		Preload("Containers", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("name = ?", name).
+		First(&stack).Error
+	if err != nil {
+		return nil, err
+	}
+	return &stack, nil
+}
+
+// runStackAction runs a start of a stack in the background, logging its
+// outcome. It returns false without running it when the stack is already
+// being started.
+func runStackAction(name string, action string, run func() error) bool {
+	if !reserveStackAction(name) {
+		return false
+	}
+	goStackAction(name, action, run)
+	return true
+}
+
+// reserveStackAction marks a stack as being started, returning false when
+// it already is
+func reserveStackAction(name string) bool {
+	stackActions.Lock()
+	defer stackActions.Unlock()
+	if stackActions.running[name] {
+		return false
+	}
+	stackActions.running[name] = true
+	return true
+}
+
+// releaseStackAction ends the reservation of reserveStackAction
+func releaseStackAction(name string) {
+	stackActions.Lock()
+	delete(stackActions.running, name)
+	stackActions.Unlock()
+}
+
+// goStackAction runs an action reserved with reserveStackAction in the
+// background, releasing the stack once it is done
+func goStackAction(name string, action string, run func() error) {
+	go func() {
+		defer releaseStackAction(name)
+
+		if err := run(); err != nil {
+			log.Printf("Failed to %s stack %s: %v", action, name, err)
+			return
+		}
+		log.Printf("Stack %s %s completed", name, action)
+	}()
+}
+
+// startStack starts the stack's containers in dependency order, waiting for
+// each container's dependencies to reach their condition first
+func startStack(stack *models.Stack) error {
+	ordered, configs := orderStackContainers(stack)
+
+	ctx := context.Background()
+	for _, containerObj := range ordered {
+		if containerConfig, ok := configs[containerObj.Name]; ok {
+			if err := waitForDependencies(ctx, containerConfig); err != nil {
+				return err
+			}
+		}
+
+		if err := startContainer(ctx, containerObj.Name, containerObj.ContainerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopStack stops the stack's containers in reverse dependency order
+func stopStack(stack *models.Stack) error {
+	ordered, _ := orderStackContainers(stack)
+
+	ctx := context.Background()
+	for i := len(ordered) - 1; i >= 0; i-- {
+		containerObj := ordered[i]
+		err := withContainerStatus(containerObj.Name, containerObj.ContainerID, models.StatusStopped, func() error {
+			return dockerClient.ContainerStop(ctx, containerObj.Name, container.StopOptions{})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startContainer starts a container of a stack and records it as running
+func startContainer(ctx context.Context, name string, containerID string) error {
+	return withContainerStatus(name, containerID, models.StatusRunning, func() error {
+		return dockerClient.ContainerStart(ctx, name, container.StartOptions{})
+	})
+}
+
+// withContainerStatus runs a Docker action on a container and records its
+// new status. It holds applyMutex so no apply replaces the container in
+// between, and only writes the status of the row that still describes the
+// same Docker container, keeping what the event watcher recorded meanwhile.
+func withContainerStatus(name string, containerID string, status models.ContainerStatus, action func() error) error {
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+
+	if err := action(); err != nil {
+		return fmt.Errorf("container '%s': %w", name, err)
+	}
+	err := database.GetDB().Model(&models.Container{}).Where("container_id = ?", containerID).Update("status", status).Error
+	if err != nil {
+		return fmt.Errorf("failed to save container '%s': %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// waitFor polls condition until it holds, failing the test after timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// running reports whether the fake engine runs a container
+func (s *testServer) running(name string) bool {
+	info, err := s.engine.ContainerInspect(context.Background(), name)
+	return err == nil && info.State.Running
+}
+
+func TestStartStackRunsInBackground(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, dependentStack)
+	waitForStack(t, "shop")
+
+	if response := s.do(admin, http.MethodPost, "/api/stacks/shop/stop", nil); response.Code != http.StatusOK {
+		t.Fatalf("stop returned %d: %s", response.Code, response.Body.String())
+	}
+	if s.running("app") || s.running("db") {
+		t.Fatal("expected the stack to be stopped")
+	}
+
+	// app waits for db to be healthy, long after the response
+	response := s.do(admin, http.MethodPost, "/api/stacks/shop/start", nil)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", response.Code, response.Body.String())
+	}
+	if s.running("app") {
+		t.Error("app started before db was healthy")
+	}
+
+	// A second start or a restart while app is waiting is refused, leaving
+	// the stack as it is
+	waitFor(t, 5*time.Second, "db to start", func() bool { return s.running("db") })
+	for _, action := range []string{"start", "restart"} {
+		if response := s.do(admin, http.MethodPost, "/api/stacks/shop/"+action, nil); response.Code != http.StatusConflict {
+			t.Errorf("expected 409 for a concurrent %s, got %d: %s", action, response.Code, response.Body.String())
+		}
+	}
+	if !s.running("db") {
+		t.Error("expected the refused restart not to stop db")
+	}
+
+	waitFor(t, 10*time.Second, "the stack to start", func() bool { return s.running("app") && s.running("db") })
+	waitFor(t, 5*time.Second, "the start to finish", func() bool {
+		return s.container("app").Status == models.StatusRunning && !stackActionRunning("shop")
+	})
+}
+
+// stackActionRunning reports whether a background start of a stack is
+// still in progress
+func stackActionRunning(name string) bool {
+	stackActions.Lock()
+	defer stackActions.Unlock()
+	return stackActions.running[name]
+}
+
+// waitForStack waits for the background start of a stack to finish
+func waitForStack(t *testing.T, name string) {
+	t.Helper()
+	waitFor(t, 10*time.Second, "stack "+name+" to start", func() bool { return !stackActionRunning(name) })
+}
+
+func TestStopRestartAndDeleteStack(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, dependentStack)
+	waitForStack(t, "shop")
+
+	response := s.do(admin, http.MethodPost, "/api/stacks/shop/stop", nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("stop returned %d: %s", response.Code, response.Body.String())
+	}
+	for _, name := range []string{"app", "db"} {
+		if s.running(name) {
+			t.Errorf("expected %s to be stopped", name)
+		}
+		if status := s.container(name).Status; status != models.StatusStopped {
+			t.Errorf("expected %s to be recorded as stopped, got %s", name, status)
+		}
+	}
+
+	response = s.do(admin, http.MethodPost, "/api/stacks/shop/restart", nil)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", response.Code, response.Body.String())
+	}
+	waitFor(t, 10*time.Second, "the stack to restart", func() bool {
+		return s.running("app") && s.running("db") && !stackActionRunning("shop")
+	})
+
+	if response := s.do(admin, http.MethodDelete, "/api/stacks/shop", nil); response.Code != http.StatusOK {
+		t.Fatalf("delete returned %d: %s", response.Code, response.Body.String())
+	}
+	for _, name := range []string{"app", "db"} {
+		if _, err := s.engine.ContainerInspect(context.Background(), name); err == nil {
+			t.Errorf("expected the Docker container %s to be removed", name)
+		}
+	}
+	if response := s.do(admin, http.MethodGet, "/api/stacks/shop", nil); response.Code != http.StatusNotFound {
+		t.Errorf("expected the stack to be gone, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := s.do(admin, http.MethodPost, "/api/stacks/shop/start", nil); response.Code != http.StatusNotFound {
+		t.Errorf("expected 404 starting a deleted stack, got %d: %s", response.Code, response.Body.String())
+	}
+}
+
+func TestStartStackKeepsConcurrentChanges(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, "name: site\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+	if response := s.do(admin, http.MethodPost, "/api/stacks/site/stop", nil); response.Code != http.StatusOK {
+		t.Fatalf("stop returned %d: %s", response.Code, response.Body.String())
+	}
+
+	// The stack is loaded, then the event watcher records a restart
+	stack, err := findStack("site")
+	if err != nil {
+		t.Fatalf("failed to load stack: %v", err)
+	}
+	web := s.container("web")
+	if err := database.GetDB().Model(&web).Updates(map[string]interface{}{"restart_count": 3, "health": models.HealthHealthy}).Error; err != nil {
+		t.Fatalf("failed to update web: %v", err)
+	}
+
+	if err := startStack(stack); err != nil {
+		t.Fatalf("failed to start stack: %v", err)
+	}
+	current := s.container("web")
+	if current.Status != models.StatusRunning {
+		t.Errorf("expected web to be recorded as running, got %s", current.Status)
+	}
+	if current.RestartCount != 3 || current.Health != models.HealthHealthy || current.SpecHash != web.SpecHash {
+		t.Errorf("expected the start to keep the changes made meanwhile, got %+v", current)
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timing of the stats collector
+const (
+	// statsScanInterval is how often containers without a stats stream are checked
+	statsScanInterval = 10 * time.Second
+	// statsWindow is how much history is kept per container. Docker sends
+	// a sample about once a second.
+	statsWindow = 5 * time.Minute
+	// statsStale is the age after which a container's latest sample is no
+	// longer its current usage, as it stopped sending samples
+	statsStale = 30 * time.Second
+)
+
+// StatsSample is a point-in-time resource usage measurement of a container.
+// Rates are in bytes per second since the previous sample.
+type StatsSample struct {
+	Time          time.Time `json:"time"`
+	CPUPercent    float64   `json:"cpu_percent"`
+	MemoryUsage   uint64    `json:"memory_usage"`
+	MemoryLimit   uint64    `json:"memory_limit"`
+	MemoryPercent float64   `json:"memory_percent"`
+	NetworkRx     float64   `json:"network_rx_rate"`
+	NetworkTx     float64   `json:"network_tx_rate"`
+	BlockRead     float64   `json:"block_read_rate"`
+	BlockWrite    float64   `json:"block_write_rate"`
+	Pids          uint64    `json:"pids"`
+}
+
+// ContainerStats is the current usage of a container and its recent history
+type ContainerStats struct {
+	Container string        `json:"container"`
+	Current   *StatsSample  `json:"current"`
+	History   []StatsSample `json:"history"`
+}
+
+// statsCollector streams stats for every running managed container and
+// keeps a rolling window of samples in memory
+type statsCollector struct {
+	mu      sync.RWMutex
+	windows map[string][]StatsSample
+	streams map[string]bool
+}
+
+// stats is the collector started with the server
+var stats = &statsCollector{
+	windows: make(map[string][]StatsSample),
+	streams: make(map[string]bool),
+}
+
+// API handlers
+func getContainerStats(c *gin.Context) {
+	containerObj, ok := statsContainer(c)
+	if !ok {
+		return
+	}
+
+	history := stats.history(containerObj.Name)
+	result := ContainerStats{Container: containerObj.Name, History: history}
+	if n := len(history); n > 0 {
+		result.Current = &history[n-1]
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func streamStats(c *gin.Context) {
+	// The server's write timeout would otherwise end the stream
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for stats stream: %v", err)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// Optionally limit the stream to one container, e.g. ?container=web
+	name := c.Query("container")
+
+	ctx := c.Request.Context()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastSent := make(map[string]time.Time)
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ticker.C:
+			for containerName, sample := range stats.latestAll() {
+				if name != "" && containerName != name {
+					continue
+				}
+				if !sample.Time.After(lastSent[containerName]) {
+					continue
+				}
+				lastSent[containerName] = sample.Time
+				c.SSEvent("stats", gin.H{"container": containerName, "sample": sample})
+			}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// Helper functions
+
+// statsContainer loads the container of a stats request, writing a 404 when
+// it doesn't exist
+func statsContainer(c *gin.Context) (*models.Container, bool) {
+	id := c.Param("id")
+	var containerObj models.Container
+
+	if err := database.GetDB().First(&containerObj, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Container not found"})
+		return nil, false
+	}
+	return &containerObj, true
+}
+
+// run streams stats for every running container until ctx is cancelled
+func (s *statsCollector) run(ctx context.Context) {
+	ticker := time.NewTicker(statsScanInterval)
+	defer ticker.Stop()
+
+	for {
+		var containerList []models.Container
+		err := database.GetDB().Where("status = ?", models.StatusRunning).Find(&containerList).Error
+		if err != nil {
+			log.Printf("Stats collector failed to load containers: %v", err)
+		}
+
+		s.mu.Lock()
+		for _, containerObj := range containerList {
+			if s.streams[containerObj.Name] {
+				continue
+			}
+			s.streams[containerObj.Name] = true
+			go s.stream(ctx, containerObj.Name)
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stream records samples of one container until it stops
+func (s *statsCollector) stream(ctx context.Context, name string) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, name)
+		s.mu.Unlock()
+
+		// The history of a removed container is of no use anymore
+		if ctx.Err() == nil {
+			if _, err := dockerClient.ContainerInspect(ctx, name); errdefs.IsNotFound(err) {
+				s.forget(name)
+			}
+		}
+	}()
+
+	response, err := dockerClient.ContainerStats(ctx, name, true)
+	if err != nil {
+		log.Printf("Stats collector failed to stream %s: %v", name, err)
+		return
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	var previous *container.StatsResponse
+	for {
+		var current container.StatsResponse
+		if err := decoder.Decode(&current); err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				log.Printf("Stats collector failed to read stats of %s: %v", name, err)
+			}
+			return
+		}
+
+		// A stopped container reports an empty sample
+		if current.Read.IsZero() || current.CPUStats.SystemUsage == 0 {
+			previous = nil
+			continue
+		}
+		s.record(name, statsSample(&current, previous))
+		previous = &current
+	}
+}
+
+// record appends a sample to a container's window, dropping samples that
+// fell out of it
+func (s *statsCollector) record(name string, sample StatsSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window := append(s.windows[name], sample)
+	cutoff := sample.Time.Add(-statsWindow)
+	start := 0
+	for start < len(window) && window[start].Time.Before(cutoff) {
+		start++
+	}
+	s.windows[name] = window[start:]
+}
+
+// history returns a copy of a container's window
+func (s *statsCollector) history(name string) []StatsSample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]StatsSample{}, s.windows[name]...)
+}
+
+// forget drops the window of a container that was removed or renamed
+func (s *statsCollector) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.windows, name)
+}
+
+// latestAll returns the most recent sample of every container still
+// sending samples
+func (s *statsCollector) latestAll() map[string]StatsSample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().Add(-statsStale)
+	latest := make(map[string]StatsSample, len(s.windows))
+	for name, window := range s.windows {
+		if len(window) > 0 && window[len(window)-1].Time.After(cutoff) {
+			latest[name] = window[len(window)-1]
+		}
+	}
+	return latest
+}
+
+// statsSample computes usage from a Docker stats response. Rates need the
+// previous response and are left at zero for the first one.
+func statsSample(current, previous *container.StatsResponse) StatsSample {
+	sample := StatsSample{
+		Time:        current.Read,
+		MemoryUsage: memoryUsage(current.MemoryStats),
+		MemoryLimit: current.MemoryStats.Limit,
+		Pids:        current.PidsStats.Current,
+	}
+
+	// Docker includes the CPU counters of the previous read in every response
+	cpuDelta := float64(current.CPUStats.CPUUsage.TotalUsage) - float64(current.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(current.CPUStats.SystemUsage) - float64(current.PreCPUStats.SystemUsage)
+	onlineCPUs := float64(current.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(current.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		sample.CPUPercent = cpuDelta / systemDelta * onlineCPUs * 100
+	}
+
+	if sample.MemoryLimit > 0 {
+		sample.MemoryPercent = float64(sample.MemoryUsage) / float64(sample.MemoryLimit) * 100
+	}
+
+	if previous != nil {
+		elapsed := current.Read.Sub(previous.Read).Seconds()
+		if elapsed > 0 {
+			rx, tx := networkBytes(current)
+			prevRx, prevTx := networkBytes(previous)
+			read, write := blockBytes(current)
+			prevRead, prevWrite := blockBytes(previous)
+
+			sample.NetworkRx = rate(rx, prevRx, elapsed)
+			sample.NetworkTx = rate(tx, prevTx, elapsed)
+			sample.BlockRead = rate(read, prevRead, elapsed)
+			sample.BlockWrite = rate(write, prevWrite, elapsed)
+		}
+	}
+
+	return sample
+}
+
+// memoryUsage returns the memory used by a container without the page
+// cache, the way `docker stats` reports it
+func memoryUsage(memory container.MemoryStats) uint64 {
+	// cgroup v2 reports inactive_file, cgroup v1 total_inactive_file
+	for _, key := range []string{"inactive_file", "total_inactive_file"} {
+		if cache, ok := memory.Stats[key]; ok && cache < memory.Usage {
+			return memory.Usage - cache
+		}
+	}
+	return memory.Usage
+}
+
+// networkBytes sums the bytes received and sent on every interface
+func networkBytes(response *container.StatsResponse) (uint64, uint64) {
+	var rx, tx uint64
+	for _, network := range response.Networks {
+		rx += network.RxBytes
+		tx += network.TxBytes
+	}
+	return rx, tx
+}
+
+// blockBytes sums the bytes read from and written to every block device
+func blockBytes(response *container.StatsResponse) (uint64, uint64) {
+	var read, write uint64
+	for _, entry := range response.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			read += entry.Value
+		case "write":
+			write += entry.Value
+		}
+	}
+	return read, write
+}
+
+// rate returns the per-second increase of a counter. A counter that went
+// down was reset, e.g. by a restart, and yields no rate.
+func rate(current, previous uint64, elapsed float64) float64 {
+	if current < previous {
+		return 0
+	}
+	return float64(current-previous) / elapsed
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/events"
+	"github.com/hspgit/DockFormer/internal/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// useStatsCollector replaces the stats collector for the rest of a test
+func useStatsCollector(t *testing.T) *statsCollector {
+	t.Helper()
+	previous := stats
+	stats = &statsCollector{
+		windows: make(map[string][]StatsSample),
+		streams: make(map[string]bool),
+	}
+	t.Cleanup(func() { stats = previous })
+	return stats
+}
+
+func TestStatsLatestAllSkipsStaleContainers(t *testing.T) {
+	collector := useStatsCollector(t)
+	now := time.Now()
+	collector.record("running", StatsSample{Time: now.Add(-time.Second), CPUPercent: 12})
+	collector.record("stopped", StatsSample{Time: now.Add(-statsStale - time.Second), CPUPercent: 50})
+
+	latest := collector.latestAll()
+	if _, ok := latest["stopped"]; ok {
+		t.Error("expected the stopped container's last sample not to be current")
+	}
+	if sample, ok := latest["running"]; !ok || sample.CPUPercent != 12 {
+		t.Errorf("expected the running container's sample, got %+v", latest)
+	}
+	if len(collector.history("stopped")) != 1 {
+		t.Error("expected the stopped container to keep its history")
+	}
+}
+
+func TestStatsForgetsRemovedAndRenamedContainers(t *testing.T) {
+	newTestServer(t)
+	collector := useStatsCollector(t)
+
+	tests := []struct {
+		name    string
+		message events.Message
+	}{
+		{
+			name: "web",
+			message: events.Message{
+				Type:   events.ContainerEventType,
+				Action: events.ActionDestroy,
+				Actor:  events.Actor{ID: "abc", Attributes: map[string]string{"name": "web"}},
+			},
+		},
+		{
+			name: "db",
+			message: events.Message{
+				Type:   events.ContainerEventType,
+				Action: events.ActionRename,
+				Actor:  events.Actor{ID: "def", Attributes: map[string]string{"name": "db-dockformer-backup", "oldName": "/db"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(string(test.message.Action), func(t *testing.T) {
+			collector.record(test.name, StatsSample{Time: time.Now()})
+			handleContainerEvent(context.Background(), test.message)
+			if history := collector.history(test.name); len(history) != 0 {
+				t.Errorf("expected the window of %s to be dropped, %d samples left", test.name, len(history))
+			}
+		})
+	}
+}
+
+func TestStatsStreamForgetsRemovedContainer(t *testing.T) {
+	s := newTestServer(t)
+	collector := useStatsCollector(t)
+	admin := s.user("admin", models.RoleAdmin)
+	s.apply(admin, "name: site\ncontainers:\n  - name: web\n    image: nginx:1.27\n    ports: \"8080:80\"\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		collector.stream(ctx, "web")
+		close(done)
+	}()
+	waitFor(t, 5*time.Second, "a stats sample", func() bool { return len(collector.history("web")) > 0 })
+
+	response := s.do(admin, http.MethodDelete, fmt.Sprintf("/api/containers/%d", s.container("web").ID), nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("delete returned %d: %s", response.Code, response.Body.String())
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stats stream to end with the container")
+	}
+	if history := collector.history("web"); len(history) != 0 {
+		t.Errorf("expected the window of web to be dropped, %d samples left", len(history))
+	}
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/gin-gonic/gin"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultSyncInterval is used when DOCKFORMER_SYNC_INTERVAL is not set
+const defaultSyncInterval = 5 * time.Minute
+
+// SyncReport lists what reconciling the database with Docker did, or would
+// do on a dry run
+type SyncReport struct {
+	DryRun bool `json:"dry_run"`
+	// Adopted containers exist in Docker but were not managed yet
+	Adopted []string `json:"adopted"`
+	// Updated containers had a stale Docker ID or status
+	Updated []string `json:"updated"`
+	// Removed containers no longer exist in Docker
+	Removed []string `json:"removed"`
+	// Skipped containers exist in Docker but don't match the adopt filter
+	Skipped []string `json:"skipped"`
+}
+
+// syncFilter selects which unmanaged Docker containers are adopted. An
+// empty filter adopts every container.
+type syncFilter struct {
+	// label is either a label key or a key=value pair
+	label string
+	// pattern must match the container name
+	pattern *regexp.Regexp
+}
+
+// API handlers
+func syncHandler(c *gin.Context) {
+	filter, err := syncFilterFromEnv()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The adopt filter can be overridden per request
+	if label := c.Query("label"); label != "" {
+		filter.label = label
+	}
+	if pattern := c.Query("pattern"); pattern != "" {
+		if filter.pattern, err = regexp.Compile(pattern); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid pattern: %v", err)})
+			return
+		}
+	}
+
+	report, err := syncContainersWithDocker(filter, c.Query("dry_run") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Helper functions
+
+// runPeriodicSync reconciles the database with Docker at startup and then
+// every DOCKFORMER_SYNC_INTERVAL until ctx is cancelled. An interval of 0
+// only syncs at startup.
+func runPeriodicSync(ctx context.Context) {
+	interval := defaultSyncInterval
+	if value := os.Getenv("DOCKFORMER_SYNC_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			log.Printf("Invalid DOCKFORMER_SYNC_INTERVAL '%s', using %s", value, defaultSyncInterval)
+		} else {
+			interval = parsed
+		}
+	}
+
+	filter, err := syncFilterFromEnv()
+	if err != nil {
+		log.Printf("Container sync disabled: %v", err)
+		return
+	}
+
+	for {
+		report, err := syncContainersWithDocker(filter, false)
+		if err != nil {
+			log.Printf("Container sync failed: %v", err)
+		} else if len(report.Adopted)+len(report.Updated)+len(report.Removed) > 0 {
+			log.Printf("Container sync adopted %d, updated %d and removed %d container(s)",
+				len(report.Adopted), len(report.Updated), len(report.Removed))
+		}
+
+		if interval == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// syncFilterFromEnv reads the adopt filter from DOCKFORMER_SYNC_LABEL and
+// DOCKFORMER_SYNC_NAME_PATTERN
+func syncFilterFromEnv() (syncFilter, error) {
+	filter := syncFilter{label: os.Getenv("DOCKFORMER_SYNC_LABEL")}
+	if pattern := os.Getenv("DOCKFORMER_SYNC_NAME_PATTERN"); pattern != "" {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return filter, fmt.Errorf("invalid DOCKFORMER_SYNC_NAME_PATTERN: %w", err)
+		}
+		filter.pattern = compiled
+	}
+	return filter, nil
+}
+
+// matches reports whether an unmanaged container should be adopted
+func (f syncFilter) matches(name string, labels map[string]string) bool {
+	if f.pattern != nil && !f.pattern.MatchString(name) {
+		return false
+	}
+	if f.label != "" {
+		key, value, hasValue := strings.Cut(f.label, "=")
+		actual, ok := labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// syncContainersWithDocker reconciles the database with the containers that
+// exist in Docker. Known containers get their Docker ID and status updated,
+// containers that disappeared are marked as removed, and unmanaged containers
+// matching filter are adopted. Nothing is written when dryRun is set.
+func syncContainersWithDocker(filter syncFilter, dryRun bool) (*SyncReport, error) {
+	ctx := context.Background()
+	db := database.GetDB()
+
+	// Containers created by an apply exist in Docker before their rows are
+	// committed, so a sync during an apply would adopt them a second time
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+
+	report := &SyncReport{
+		DryRun:  dryRun,
+		Adopted: []string{},
+		Updated: []string{},
+		Removed: []string{},
+		Skipped: []string{},
+	}
+
+	// Get all containers from Docker
+	containerList, err := dockerClient.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all containers from database
+	var dbContainers []models.Container
+	if err := db.Find(&dbContainers).Error; err != nil {
+		return nil, err
+	}
+
+	// Map for quick lookup of db containers
+	dbContainerMap := make(map[string]models.Container)
+	for _, c := range dbContainers {
+		dbContainerMap[c.Name] = c
+	}
+
+	for _, c := range containerList {
+		// Containers being removed can be listed without a name
+		if len(c.Names) == 0 {
+			continue
+		}
+
+		// Use the container name without the leading slash
+		name := strings.TrimPrefix(c.Names[0], "/")
+
+		// Containers set aside during an apply are transient
+		if strings.Contains(name, backupSuffix) {
+			continue
+		}
+
+		status := models.ContainerStatus(c.State)
+		if dbContainer, exists := dbContainerMap[name]; exists {
+			delete(dbContainerMap, name)
+			if dbContainer.ContainerID == c.ID && dbContainer.Status == status {
+				continue
+			}
+
+			report.Updated = append(report.Updated, name)
+			if !dryRun {
+				err := db.Model(&dbContainer).Updates(map[string]interface{}{
+					"container_id": c.ID,
+					"status":       status,
+				}).Error
+				if err != nil {
+					return nil, fmt.Errorf("failed to update container '%s': %w", name, err)
+				}
+			}
+			continue
+		}
+
+		if !filter.matches(name, c.Labels) {
+			report.Skipped = append(report.Skipped, name)
+			continue
+		}
+
+		report.Adopted = append(report.Adopted, name)
+		if dryRun {
+			continue
+		}
+
+		newContainer := models.Container{
+			Name:        name,
+			Image:       c.Image,
+			ContainerID: c.ID,
+			Status:      status,
+			Ports:       listedPorts(c.Ports),
+		}
+		// Containers created by DockFormer keep their stack
+		if stackName := c.Labels[labelStack]; stackName != "" {
+			var stack models.Stack
+			err := db.Where("name = ?", stackName).First(&stack).Error
+			if err == nil {
+				newContainer.StackID = &stack.ID
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+		}
+		if err := db.Create(&newContainer).Error; err != nil {
+			return nil, fmt.Errorf("failed to adopt container '%s': %w", name, err)
+		}
+	}
+
+	// Whatever is left in the map no longer exists in Docker
+	for name, dbContainer := range dbContainerMap {
+		if dbContainer.Status == models.StatusRemoved {
+			continue
+		}
+
+		report.Removed = append(report.Removed, name)
+		if !dryRun {
+			if err := db.Model(&dbContainer).Update("status", models.StatusRemoved).Error; err != nil {
+				return nil, fmt.Errorf("failed to mark container '%s' as removed: %w", name, err)
+			}
+		}
+	}
+	sort.Strings(report.Removed)
+
+	return report, nil
+}
+
+// listedPorts renders the published ports of a listed container in the
+// "host:container" format used in the YAML
+func listedPorts(ports []container.Port) string {
+	seen := make(map[string]bool)
+	var portStrings []string
+	for _, p := range ports {
+		if p.PublicPort == 0 {
+			continue
+		}
+		// Docker lists a binding once per address family
+		mapping := fmt.Sprintf("%d:%d", p.PublicPort, p.PrivatePort)
+		if p.Type != "" && p.Type != "tcp" {
+			mapping += "/" + p.Type
+		}
+		if !seen[mapping] {
+			seen[mapping] = true
+			portStrings = append(portStrings, mapping)
+		}
+	}
+	return strings.Join(portStrings, ",")
+}
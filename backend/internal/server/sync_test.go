@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"github.com/docker/docker/api/types/container"
+	"github.com/hspgit/DockFormer/internal/database"
+	"github.com/hspgit/DockFormer/internal/models"
+	"testing"
+	"time"
+)
+
+func TestSyncWaitsForApplies(t *testing.T) {
+	s := newTestServer(t)
+
+	// An apply has created web in Docker but not committed its row yet
+	applyMutex.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			applyMutex.Unlock()
+		}
+	}()
+	if err := fakeRun(s.engine, "web", &container.Config{Image: "nginx:1.27"}); err != nil {
+		t.Fatalf("failed to run web: %v", err)
+	}
+
+	type syncResult struct {
+		report *SyncReport
+		err    error
+	}
+	done := make(chan syncResult, 1)
+	go func() {
+		report, err := syncContainersWithDocker(syncFilter{}, false)
+		done <- syncResult{report, err}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("sync ran during the apply")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	info, err := s.engine.ContainerInspect(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("failed to inspect web: %v", err)
+	}
+	row := models.Container{Name: "web", Image: "nginx:1.27", ContainerID: info.ID, Status: models.StatusRunning}
+	if err := database.GetDB().Create(&row).Error; err != nil {
+		t.Fatalf("failed to save web: %v", err)
+	}
+	applyMutex.Unlock()
+	locked = false
+
+	var result syncResult
+	select {
+	case result = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the sync")
+	}
+	if result.err != nil {
+		t.Fatalf("sync failed: %v", result.err)
+	}
+	if len(result.report.Adopted) != 0 {
+		t.Errorf("expected nothing to be adopted, got %v", result.report.Adopted)
+	}
+	var count int64
+	database.GetDB().Model(&models.Container{}).Where("name = ?", "web").Count(&count)
+	if count != 1 {
+		t.Errorf("expected a single row for web, got %d", count)
+	}
+}
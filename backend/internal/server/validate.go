@@ -0,0 +1,517 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/distribution/reference"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError is a single problem found in a YAML file
+type ValidationError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error returns the problem prefixed with its position
+func (e ValidationError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %s", e.Line, e.Column, e.Field, e.Message)
+}
+
+// ValidationErrors collects every problem found in a YAML file so they can
+// be reported at once
+type ValidationErrors []ValidationError
+
+// Error returns every problem, one per line
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// add records a problem at the position of node
+func (e *ValidationErrors) add(node *yaml.Node, field string, message string) {
+	validationError := ValidationError{Field: field, Message: message}
+	if node != nil {
+		validationError.Line = node.Line
+		validationError.Column = node.Column
+	}
+	*e = append(*e, validationError)
+}
+
+var (
+	// containerNamePattern matches the names Docker accepts for containers
+	containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+	// yamlLinePattern extracts the line number from yaml.v3 error messages
+	yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	// volumeModes lists the options allowed after a bind mount's target
+	volumeModes = map[string]bool{
+		"ro": true, "rw": true, "z": true, "Z": true, "nocopy": true,
+		"shared": true, "rshared": true, "slave": true, "rslave": true, "private": true, "rprivate": true,
+		"consistent": true, "cached": true, "delegated": true,
+	}
+)
+
+// API handlers
+func validateHandler(c *gin.Context) {
+	yamlData, _, err := readYamlRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, errs := loadContainersConfig(yamlData); len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"valid": false, "errors": errs})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"valid": true, "errors": ValidationErrors{}})
+}
+
+// Helper functions
+
+// configErrorBody builds the response body for a YAML file that failed to
+// parse, listing every validation problem separately
+func configErrorBody(err error) gin.H {
+	var validationErrors ValidationErrors
+	if errors.As(err, &validationErrors) {
+		return gin.H{
+			"error":  fmt.Sprintf("Invalid YAML configuration: %d problem(s) found", len(validationErrors)),
+			"errors": validationErrors,
+		}
+	}
+	return gin.H{"error": "Failed to parse YAML: " + err.Error()}
+}
+
+// loadContainersConfig parses and strictly validates a YAML file in either
+// DockFormer's or the Compose format, returning every problem found along
+// with its line and column
+func loadContainersConfig(yamlData []byte) (ContainersConfig, ValidationErrors) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(yamlData, &doc); err != nil {
+		return ContainersConfig{}, yamlErrors(err, nil)
+	}
+
+	root := documentRoot(&doc)
+	if root == nil {
+		return ContainersConfig{}, ValidationErrors{{Line: 1, Column: 1, Message: "document is empty"}}
+	}
+	if root.Kind != yaml.MappingNode {
+		errs := ValidationErrors{}
+		errs.add(root, "", "expected a mapping at the top level")
+		return ContainersConfig{}, errs
+	}
+
+	var config ContainersConfig
+	var errs ValidationErrors
+	var containerNodes []*yaml.Node
+	var containerPath func(i int) string
+
+	if isComposeDocument(&doc) {
+		config, errs = loadCompose(&doc)
+		services := mappingValue(root, "services")
+		for i := 1; i < len(services.Content); i += 2 {
+			containerNodes = append(containerNodes, services.Content[i])
+		}
+		containerPath = func(i int) string { return "services." + services.Content[2*i].Value }
+	} else {
+		checkFields(root, reflect.TypeOf(config), "", &errs)
+		if err := root.Decode(&config); err != nil {
+			// Skip decoder errors on lines already reported, such as the
+			// decoder's own duplicate key errors
+			reported := make(map[int]bool, len(errs))
+			for _, validationError := range errs {
+				reported[validationError.Line] = true
+			}
+			for _, validationError := range yamlErrors(err, root) {
+				if !reported[validationError.Line] {
+					errs = append(errs, validationError)
+				}
+			}
+			return ContainersConfig{}, sortValidationErrors(errs)
+		}
+		if containers := mappingValue(root, "containers"); containers != nil {
+			containerNodes = containers.Content
+		}
+		containerPath = func(i int) string { return fmt.Sprintf("containers[%d]", i) }
+	}
+
+	if len(config.Containers) == 0 {
+		errs.add(root, "", "no containers defined")
+	}
+	errs = append(errs, validateContainers(config, containerNodes, containerPath)...)
+	errs = append(errs, validateNetworks(config, mappingValue(root, "networks"))...)
+
+	if len(errs) > 0 {
+		return ContainersConfig{}, sortValidationErrors(errs)
+	}
+	return config, nil
+}
+
+// validateContainers checks the decoded containers, reporting problems at
+// the position of the YAML node they came from
+func validateContainers(config ContainersConfig, nodes []*yaml.Node, containerPath func(i int) string) ValidationErrors {
+	var errs ValidationErrors
+	names := make(map[string]*yaml.Node)
+	declared := containerNames(config)
+
+	for i, containerConfig := range config.Containers {
+		if i >= len(nodes) {
+			break
+		}
+		node := nodes[i]
+		path := containerPath(i)
+
+		// Name
+		nameNode := fieldNode(node, "name", "container_name")
+		switch {
+		case containerConfig.Name == "":
+			errs.add(node, path+".name", "name is required")
+		case !containerNamePattern.MatchString(containerConfig.Name):
+			errs.add(nameNode, path+".name", fmt.Sprintf("invalid container name '%s'", containerConfig.Name))
+		default:
+			if first, ok := names[containerConfig.Name]; ok {
+				errs.add(nameNode, path+".name", fmt.Sprintf("duplicate container name '%s' (first defined on line %d)", containerConfig.Name, first.Line))
+			} else {
+				names[containerConfig.Name] = nameNode
+			}
+		}
+
+		// Image
+		imageNode := fieldNode(node, "image")
+		if containerConfig.Image == "" {
+			errs.add(node, path+".image", "image is required")
+		} else if _, err := reference.ParseNormalizedNamed(containerConfig.Image); err != nil {
+			errs.add(imageNode, path+".image", fmt.Sprintf("invalid image reference '%s': %v", containerConfig.Image, err))
+		}
+
+		// Ports
+		portsNode := fieldNode(node, "ports")
+		for _, port := range strings.Split(containerConfig.Ports, ",") {
+			port = strings.TrimSpace(port)
+			if port == "" {
+				continue
+			}
+			if err := validatePortMapping(port); err != nil {
+				errs.add(portsNode, path+".ports", err.Error())
+			}
+		}
+
+		// Volumes
+		volumesNode := fieldNode(node, "volumes")
+		for j, volume := range containerConfig.Volumes {
+			volumeNode := volumesNode
+			if volumesNode.Kind == yaml.SequenceNode && j < len(volumesNode.Content) {
+				volumeNode = volumesNode.Content[j]
+			}
+			if err := validateVolume(volume); err != nil {
+				errs.add(volumeNode, fmt.Sprintf("%s.volumes[%d]", path, j), err.Error())
+			}
+		}
+
+		// Environment
+		envNode := fieldNode(node, "env", "environment")
+		for key := range containerConfig.Env {
+			if key == "" || strings.ContainsAny(key, "= \t") {
+				errs.add(envNode, path+".env", fmt.Sprintf("invalid environment variable name '%s'", key))
+			}
+		}
+
+		// Labels, where the dockformer. prefix is kept for DockFormer's own
+		labelsNode := fieldNode(node, "labels")
+		for key := range containerConfig.Labels {
+			switch {
+			case key == "" || strings.ContainsAny(key, "= \t"):
+				errs.add(labelsNode, path+".labels", fmt.Sprintf("invalid label name '%s'", key))
+			case strings.HasPrefix(key, "dockformer."):
+				errs.add(labelsNode, path+".labels", fmt.Sprintf("label '%s' uses the reserved dockformer. prefix", key))
+			}
+		}
+
+		// Dependencies
+		dependsNode := fieldNode(node, "depends_on")
+		for _, name := range containerConfig.dependencyNames() {
+			if !declared[name] {
+				errs.add(dependsNode, path+".depends_on", fmt.Sprintf("depends on unknown container '%s'", name))
+			}
+			switch condition := containerConfig.DependsOn[name].Condition; condition {
+			case ConditionStarted, ConditionHealthy, ConditionCompletedSuccessfully:
+			default:
+				errs.add(dependsNode, path+".depends_on", fmt.Sprintf("invalid condition '%s' for dependency '%s'", condition, name))
+			}
+		}
+
+		// Healthcheck and resource limits
+		if containerConfig.Healthcheck != nil {
+			if _, err := containerConfig.Healthcheck.toDocker(); err != nil {
+				errs.add(fieldNode(node, "healthcheck"), path+".healthcheck", err.Error())
+			}
+		}
+		if _, _, err := containerConfig.resources(); err != nil {
+			errs.add(node, path, err.Error())
+		}
+	}
+
+	// Cycles are only meaningful once every dependency exists
+	if len(errs) == 0 {
+		_, err := orderContainers(config.Containers)
+		var cycle *cycleError
+		if errors.As(err, &cycle) {
+			for i, containerConfig := range config.Containers {
+				if containerConfig.Name == cycle.names[0] && i < len(nodes) {
+					errs.add(fieldNode(nodes[i], "depends_on"), containerPath(i)+".depends_on", err.Error())
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// validateNetworks checks the top-level networks section. External networks
+// already exist, so they can't set how DockFormer would create them.
+func validateNetworks(config ContainersConfig, node *yaml.Node) ValidationErrors {
+	var errs ValidationErrors
+	names := make([]string, 0, len(config.Networks))
+	for name := range config.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		networkConfig := config.Networks[name]
+		if networkConfig.External && (networkConfig.Driver != "" || networkConfig.Subnet != "" || networkConfig.Internal) {
+			errs.add(fieldNode(node, name), "networks."+name, "external network can't set a driver, subnet or internal")
+		}
+	}
+	return errs
+}
+
+// containerNames returns the set of container names in the configuration
+func containerNames(config ContainersConfig) map[string]bool {
+	names := make(map[string]bool, len(config.Containers))
+	for _, containerConfig := range config.Containers {
+		names[containerConfig.Name] = true
+	}
+	return names
+}
+
+// validatePortMapping checks a single "HOST:CONTAINER[/PROTOCOL]" mapping
+func validatePortMapping(port string) error {
+	hostPort, containerPort, ok := strings.Cut(port, ":")
+	if !ok || strings.Contains(containerPort, ":") {
+		return fmt.Errorf("invalid port mapping '%s', expected HOST:CONTAINER[/PROTOCOL]", port)
+	}
+
+	containerPort, protocol, hasProtocol := strings.Cut(containerPort, "/")
+	if hasProtocol && protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return fmt.Errorf("invalid protocol '%s' in port mapping '%s'", protocol, port)
+	}
+
+	for _, p := range []string{hostPort, containerPort} {
+		number, err := strconv.Atoi(p)
+		if err != nil || number < 1 || number > 65535 {
+			return fmt.Errorf("invalid port '%s' in port mapping '%s'", p, port)
+		}
+	}
+	return nil
+}
+
+// validateVolume checks a single "SOURCE:TARGET[:MODE]" volume
+func validateVolume(volume string) error {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid volume '%s', expected SOURCE:TARGET[:MODE]", volume)
+	}
+
+	source, target := parts[0], parts[1]
+	switch {
+	case source == "":
+		return fmt.Errorf("volume '%s' has an empty source", volume)
+	case strings.HasPrefix(source, "."):
+		return fmt.Errorf("volume '%s' uses a relative host path, host paths must be absolute", volume)
+	case !strings.HasPrefix(source, "/") && !containerNamePattern.MatchString(source):
+		return fmt.Errorf("volume '%s' has an invalid volume name", volume)
+	}
+	if !strings.HasPrefix(target, "/") {
+		return fmt.Errorf("volume '%s' must have an absolute target path", volume)
+	}
+
+	if len(parts) == 3 {
+		for _, mode := range strings.Split(parts[2], ",") {
+			if !volumeModes[mode] {
+				return fmt.Errorf("invalid mode '%s' in volume '%s'", mode, volume)
+			}
+		}
+	}
+	return nil
+}
+
+// checkFields walks a YAML node alongside the Go type it decodes into and
+// reports unknown fields and duplicate keys. Type mismatches are left for
+// the decoder to report.
+func checkFields(node *yaml.Node, t reflect.Type, path string, errs *ValidationErrors) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		seen := make(map[string]*yaml.Node)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinFieldPath(path, key.Value)
+			if first, ok := seen[key.Value]; ok {
+				errs.add(key, keyPath, fmt.Sprintf("duplicate key '%s' (first defined on line %d)", key.Value, first.Line))
+				continue
+			}
+			seen[key.Value] = key
+
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				errs.add(key, keyPath, fmt.Sprintf("unknown field '%s'", key.Value))
+				continue
+			}
+			checkFields(value, fieldType, keyPath, errs)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		seen := make(map[string]*yaml.Node)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinFieldPath(path, key.Value)
+			if first, ok := seen[key.Value]; ok {
+				errs.add(key, keyPath, fmt.Sprintf("duplicate key '%s' (first defined on line %d)", key.Value, first.Line))
+				continue
+			}
+			seen[key.Value] = key
+			checkFields(value, t.Elem(), keyPath, errs)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			checkFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of a struct to the types of its fields
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// joinFieldPath appends a key to a dotted field path
+func joinFieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// fieldNode returns the value of the first key present in a mapping node,
+// falling back to the mapping itself so problems still get a position
+func fieldNode(node *yaml.Node, keys ...string) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if value := mappingValue(node, key); value != nil {
+			return value
+		}
+	}
+	return node
+}
+
+// yamlErrors converts yaml.v3 syntax and type errors into validation errors.
+// The decoder only reports lines, so the column is taken from the first node
+// on that line when the document is available.
+func yamlErrors(err error, root *yaml.Node) ValidationErrors {
+	messages := []string{err.Error()}
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		messages = typeError.Errors
+	}
+
+	var errs ValidationErrors
+	for _, message := range messages {
+		validationError := ValidationError{Message: strings.TrimPrefix(message, "yaml: ")}
+		if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+			validationError.Line, _ = strconv.Atoi(match[1])
+			validationError.Message = match[2]
+			validationError.Column = columnAtLine(root, validationError.Line)
+		}
+		errs = append(errs, validationError)
+	}
+	return errs
+}
+
+// decodeMessage strips yaml.v3's position prefixes from a decode error, for
+// callers that already know which node failed
+func decodeMessage(err error) string {
+	var messages []string
+	for _, validationError := range yamlErrors(err, nil) {
+		messages = append(messages, validationError.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// columnAtLine returns the column of the first node found on a line
+func columnAtLine(node *yaml.Node, line int) int {
+	if node == nil {
+		return 0
+	}
+	if node.Line == line {
+		return node.Column
+	}
+	for _, child := range node.Content {
+		if column := columnAtLine(child, line); column != 0 {
+			return column
+		}
+	}
+	return 0
+}
+
+// sortValidationErrors orders problems by their position in the file
+func sortValidationErrors(errs ValidationErrors) ValidationErrors {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+	return errs
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadContainersConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		expected []string
+	}{
+		{
+			name: "valid",
+			yaml: `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80"
+    depends_on: [db]
+  - name: db
+    image: postgres:16
+    ports: ""
+`,
+		},
+		{
+			name:     "empty document",
+			yaml:     "",
+			expected: []string{"line 1, column 1: document is empty"},
+		},
+		{
+			name:     "no containers",
+			yaml:     "name: site\n",
+			expected: []string{"line 1, column 1: no containers defined"},
+		},
+		{
+			name: "unknown fields",
+			yaml: `name: site
+replicas: 2
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80"
+    envs:
+      MODE: debug
+    healthcheck:
+      test: curl localhost
+      grace: 5s
+`,
+			expected: []string{
+				"line 2, column 1: replicas: unknown field 'replicas'",
+				"line 7, column 5: containers[0].envs: unknown field 'envs'",
+				"line 11, column 7: containers[0].healthcheck.grace: unknown field 'grace'",
+			},
+		},
+		{
+			name: "duplicate keys",
+			yaml: `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    image: nginx:1.28
+    ports: "8080:80"
+`,
+			expected: []string{"line 5, column 5: containers[0].image: duplicate key 'image' (first defined on line 4)"},
+		},
+		{
+			name: "invalid fields",
+			yaml: `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80, 80"
+    volumes:
+      - ./data:/data
+    labels:
+      dockformer.stack: other
+  - name: web
+    ports: ""
+`,
+			expected: []string{
+				"line 5, column 12: containers[0].ports: invalid port mapping '80', expected HOST:CONTAINER[/PROTOCOL]",
+				"line 7, column 9: containers[0].volumes[0]: volume './data:/data' uses a relative host path, host paths must be absolute",
+				"line 9, column 7: containers[0].labels: label 'dockformer.stack' uses the reserved dockformer. prefix",
+				"line 10, column 5: containers[1].image: image is required",
+				"line 10, column 11: containers[1].name: duplicate container name 'web' (first defined on line 3)",
+			},
+		},
+		{
+			name: "external network with options",
+			yaml: `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80"
+    networks: [shared]
+networks:
+  shared:
+    external: true
+    driver: bridge
+`,
+			expected: []string{"line 9, column 5: networks.shared: external network can't set a driver, subnet or internal"},
+		},
+		{
+			name: "external Compose network with options",
+			yaml: `services:
+  app:
+    image: shop/app:1.0
+    networks: [shared]
+networks:
+  shared:
+    external: true
+    driver: overlay
+`,
+			expected: []string{"line 7, column 5: networks.shared: external network can't set a driver, subnet or internal"},
+		},
+		{
+			name: "unknown dependency",
+			yaml: `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80"
+    depends_on: [cache]
+`,
+			expected: []string{"line 6, column 17: containers[0].depends_on: depends on unknown container 'cache'"},
+		},
+		{
+			name: "invalid condition",
+			yaml: `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80"
+    depends_on:
+      db:
+        condition: ready
+  - name: db
+    image: postgres:16
+    ports: ""
+`,
+			expected: []string{"line 7, column 7: containers[0].depends_on: invalid condition 'ready' for dependency 'db'"},
+		},
+		{
+			name: "dependency cycle",
+			yaml: `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80"
+    depends_on: [app]
+  - name: app
+    image: shop/app:1.0
+    ports: ""
+    depends_on: [web]
+`,
+			expected: []string{"line 6, column 17: containers[0].depends_on: dependency cycle: web -> app -> web"},
+		},
+		{
+			name: "self dependency",
+			yaml: `name: site
+containers:
+  - name: web
+    image: nginx:1.27
+    ports: "8080:80"
+    depends_on: [web]
+`,
+			expected: []string{"line 6, column 17: containers[0].depends_on: dependency cycle: web -> web"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, errs := loadContainersConfig([]byte(test.yaml))
+
+			var messages []string
+			for _, err := range errs {
+				messages = append(messages, err.Error())
+			}
+			if !reflect.DeepEqual(messages, test.expected) {
+				t.Errorf("expected errors\n%q\ngot\n%q", test.expected, messages)
+			}
+			if len(errs) == 0 && len(config.Containers) == 0 {
+				t.Error("expected the containers of a valid file")
+			}
+		})
+	}
+}
+
+func TestValidatePortMapping(t *testing.T) {
+	tests := []struct {
+		port  string
+		valid bool
+	}{
+		{"8080:80", true},
+		{"53:53/udp", true},
+		{"80", false},
+		{"127.0.0.1:8080:80", false},
+		{"8080:80/icmp", false},
+		{"0:80", false},
+		{"8080:65536", false},
+		{"http:80", false},
+	}
+	for _, test := range tests {
+		t.Run(test.port, func(t *testing.T) {
+			if err := validatePortMapping(test.port); (err == nil) != test.valid {
+				t.Errorf("validatePortMapping(%q) = %v, expected valid %v", test.port, err, test.valid)
+			}
+		})
+	}
+}
+
+func TestValidateVolume(t *testing.T) {
+	tests := []struct {
+		volume string
+		valid  bool
+	}{
+		{"/srv/data:/data", true},
+		{"data:/data:ro", true},
+		{"data:/data:ro,z", true},
+		{"/data", false},
+		{":/data", false},
+		{"../data:/data", false},
+		{"data:data", false},
+		{"data:/data:rx", false},
+		{"bad name:/data", false},
+	}
+	for _, test := range tests {
+		t.Run(test.volume, func(t *testing.T) {
+			if err := validateVolume(test.volume); (err == nil) != test.valid {
+				t.Errorf("validateVolume(%q) = %v, expected valid %v", test.volume, err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Interactive terminal for the container exec page
+document.addEventListener('DOMContentLoaded', function() {
+    const element = document.getElementById('terminal');
+    const shellForm = document.getElementById('shellForm');
+    const shellInput = document.getElementById('shell');
+    const state = document.getElementById('terminalState');
+    const containerId = element.dataset.containerId;
+
+    const terminal = new Terminal({cursorBlink: true});
+    const fitAddon = new FitAddon.FitAddon();
+    terminal.loadAddon(fitAddon);
+    terminal.open(element);
+    fitAddon.fit();
+
+    let socket = null;
+
+    function send(message) {
+        if (socket && socket.readyState === WebSocket.OPEN) {
+            socket.send(JSON.stringify(message));
+        }
+    }
+
+    function sendSize() {
+        send({type: 'resize', cols: terminal.cols, rows: terminal.rows});
+    }
+
+    function connect(shell) {
+        if (socket) {
+            socket.close();
+        }
+        terminal.reset();
+
+        const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+        const query = new URLSearchParams({shell: shell});
+        socket = new WebSocket(`${protocol}//${window.location.host}/api/containers/${containerId}/exec?${query}`);
+        socket.binaryType = 'arraybuffer';
+        state.textContent = 'Connecting...';
+
+        socket.onopen = function() {
+            state.textContent = 'Connected';
+            sendSize();
+            terminal.focus();
+        };
+        socket.onmessage = function(event) {
+            terminal.write(new Uint8Array(event.data));
+        };
+        socket.onclose = function(event) {
+            state.textContent = event.reason ? `Disconnected: ${event.reason}` : 'Disconnected';
+        };
+    }
+
+    terminal.onData(function(data) {
+        send({type: 'input', data: data});
+    });
+    terminal.onResize(sendSize);
+    window.addEventListener('resize', function() {
+        fitAddon.fit();
+    });
+
+    shellForm.addEventListener('submit', function(event) {
+        event.preventDefault();
+        connect(shellInput.value);
+    });
+
+    connect(shellInput.value);
+});
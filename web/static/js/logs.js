@@ -0,0 +1,91 @@
+// Live log streaming for the container logs page
+document.addEventListener('DOMContentLoaded', function() {
+    const logs = document.getElementById('logs');
+    const pauseButton = document.getElementById('pauseLogs');
+    const autoScroll = document.getElementById('autoScroll');
+    const state = document.getElementById('logsState');
+    const containerId = logs.dataset.containerId;
+
+    let source = null;
+    let paused = false;
+    let pending = [];
+    let lastTimestamp = null;
+
+    function renderRecord(record) {
+        const line = document.createElement('div');
+        line.className = `log-line log-${record.stream}`;
+
+        const time = document.createElement('span');
+        time.className = 'log-time';
+        time.textContent = record.timestamp;
+        line.appendChild(time);
+
+        line.appendChild(document.createTextNode(record.text));
+        return line;
+    }
+
+    function appendRecords(records) {
+        const fragment = document.createDocumentFragment();
+        records.forEach(function(record) {
+            fragment.appendChild(renderRecord(record));
+        });
+        logs.appendChild(fragment);
+        if (autoScroll.checked) {
+            logs.scrollTop = logs.scrollHeight;
+        }
+    }
+
+    function connect(params) {
+        if (source) {
+            source.close();
+        }
+
+        const query = new URLSearchParams(params);
+        source = new EventSource(`/api/containers/${containerId}/logs/stream?${query}`);
+        state.textContent = 'Connecting...';
+
+        source.onopen = function() {
+            state.textContent = paused ? 'Paused' : 'Streaming';
+        };
+        source.addEventListener('log', function(event) {
+            const record = JSON.parse(event.data);
+            lastTimestamp = record.timestamp;
+            if (paused) {
+                pending.push(record);
+            } else {
+                appendRecords([record]);
+            }
+        });
+        source.addEventListener('end', function() {
+            state.textContent = 'Container stopped, stream ended';
+            source.close();
+        });
+        source.onerror = function() {
+            // EventSource reconnects by itself, resume from the last line seen
+            state.textContent = 'Disconnected, reconnecting...';
+            if (lastTimestamp) {
+                const since = Math.floor(new Date(lastTimestamp).getTime() / 1000);
+                connect({tail: 'all', since: since});
+            }
+        };
+    }
+
+    pauseButton.addEventListener('click', function() {
+        paused = !paused;
+        pauseButton.textContent = paused ? 'Resume' : 'Pause';
+        state.textContent = paused ? 'Paused' : 'Streaming';
+        if (!paused && pending.length > 0) {
+            appendRecords(pending);
+            pending = [];
+        }
+    });
+
+    // Filters only toggle classes, so hidden lines come back when re-enabled
+    [['showStdout', 'hide-stdout'], ['showStderr', 'hide-stderr'], ['showTimestamps', 'hide-timestamps']].forEach(function(filter) {
+        document.getElementById(filter[0]).addEventListener('change', function(event) {
+            logs.classList.toggle(filter[1], !event.target.checked);
+        });
+    });
+
+    connect({tail: '100'});
+});
@@ -1,3 +1,9 @@
+// CSRF token of the session, sent with every state-changing request
+function csrfToken() {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.content : '';
+}
+
 // View container details
 function viewContainer(id) {
     window.location.href = `/api/containers/${id}`;
@@ -8,6 +14,7 @@ function deleteContainer(id) {
     if (confirm('Are you sure you want to delete this container?')) {
         fetch(`/api/containers/${id}`, {
             method: 'DELETE',
+            headers: {'X-CSRF-Token': csrfToken()},
         })
         .then(response => response.json())
         .then(data => {
@@ -20,6 +27,99 @@ function deleteContainer(id) {
     }
 }
 
+// Start, stop or restart every container in a stack
+function stackAction(name, action) {
+    fetch(`/api/stacks/${encodeURIComponent(name)}/${action}`, {
+        method: 'POST',
+        headers: {'X-CSRF-Token': csrfToken()},
+    })
+    .then(response => response.json())
+    .then(data => {
+        if (data.error) {
+            alert(`Error: ${data.error}`);
+        }
+        window.location.reload();
+    })
+    .catch(error => {
+        alert(`Error running ${action} on stack: ` + error);
+    });
+}
+
+// Delete stack and all of its containers
+function deleteStack(name) {
+    if (confirm(`Are you sure you want to delete stack "${name}" and all of its containers?`)) {
+        fetch(`/api/stacks/${encodeURIComponent(name)}`, {
+            method: 'DELETE',
+            headers: {'X-CSRF-Token': csrfToken()},
+        })
+        .then(response => response.json())
+        .then(data => {
+            alert('Stack deleted successfully');
+            window.location.reload();
+        })
+        .catch(error => {
+            alert('Error deleting stack: ' + error);
+        });
+    }
+}
+
+// Render the plan returned by /api/plan and ask for confirmation
+function renderPlan(plan, form) {
+    const planDiv = document.getElementById('plan');
+    const sections = [
+        ['create', 'Create'],
+        ['recreate', 'Recreate'],
+        ['unchanged', 'Unchanged'],
+        ['orphaned', 'Orphaned'],
+    ];
+
+    planDiv.innerHTML = '';
+    const title = document.createElement('h3');
+    title.textContent = `Plan for stack "${plan.stack}"`;
+    planDiv.appendChild(title);
+
+    sections.forEach(([key, label]) => {
+        const entries = plan[key] || [];
+        if (entries.length === 0) {
+            return;
+        }
+        const heading = document.createElement('h4');
+        heading.textContent = `${label} (${entries.length})`;
+        planDiv.appendChild(heading);
+
+        const list = document.createElement('ul');
+        list.className = `plan-${key}`;
+        entries.forEach(entry => {
+            const item = document.createElement('li');
+            item.textContent = `${entry.name} (${entry.image})`;
+            (entry.changes || []).forEach(change => {
+                const detail = document.createElement('div');
+                detail.className = 'plan-change';
+                detail.textContent = `${change.field}: ${change.current || '(none)'} → ${change.desired || '(none)'}`;
+                item.appendChild(detail);
+            });
+            list.appendChild(item);
+        });
+        planDiv.appendChild(list);
+    });
+
+    const applyButton = document.createElement('button');
+    applyButton.className = 'btn btn-primary';
+    applyButton.textContent = 'Apply';
+    applyButton.addEventListener('click', () => form.submit());
+
+    const cancelButton = document.createElement('button');
+    cancelButton.className = 'btn btn-secondary';
+    cancelButton.textContent = 'Cancel';
+    cancelButton.addEventListener('click', () => {
+        planDiv.hidden = true;
+    });
+
+    planDiv.appendChild(applyButton);
+    planDiv.appendChild(cancelButton);
+    planDiv.hidden = false;
+}
+
 // Add file name to label when file is selected
 document.addEventListener('DOMContentLoaded', function() {
     const fileInput = document.getElementById('yamlFile');
@@ -34,4 +134,114 @@ document.addEventListener('DOMContentLoaded', function() {
             }
         });
     }
-});
\ No newline at end of file
+
+    // Show the plan before applying an upload
+    const uploadForm = document.getElementById('uploadForm');
+    if (uploadForm) {
+        uploadForm.addEventListener('submit', function(event) {
+            event.preventDefault();
+            fetch('/api/plan', {
+                method: 'POST',
+                body: new FormData(uploadForm),
+            })
+            .then(response => response.json())
+            .then(data => {
+                if (data.error) {
+                    const details = (data.errors || [])
+                        .map(e => `Line ${e.line}, column ${e.column}: ${e.field ? e.field + ': ' : ''}${e.message}`)
+                        .join('\n');
+                    alert('Error planning upload: ' + data.error + (details ? '\n\n' + details : ''));
+                    return;
+                }
+                renderPlan(data, uploadForm);
+            })
+            .catch(error => {
+                alert('Error planning upload: ' + error);
+            });
+        });
+    }
+});
+// Live resource usage sparklines for running containers
+document.addEventListener('DOMContentLoaded', function() {
+    const maxSamples = 300;
+    const rows = {};
+    document.querySelectorAll('tr[data-container-name]').forEach(function(row) {
+        const canvas = row.querySelector('canvas.sparkline');
+        if (canvas) {
+            rows[row.dataset.containerName] = {row: row, canvas: canvas, samples: []};
+        }
+    });
+    if (Object.keys(rows).length === 0) {
+        return;
+    }
+
+    function formatBytes(bytes) {
+        const units = ['B', 'KiB', 'MiB', 'GiB'];
+        let i = 0;
+        while (bytes >= 1024 && i < units.length - 1) {
+            bytes /= 1024;
+            i++;
+        }
+        return `${bytes.toFixed(i === 0 ? 0 : 1)} ${units[i]}`;
+    }
+
+    function draw(entry) {
+        const canvas = entry.canvas;
+        const context = canvas.getContext('2d');
+        context.clearRect(0, 0, canvas.width, canvas.height);
+
+        const samples = entry.samples;
+        if (samples.length === 0) {
+            return;
+        }
+        const peak = Math.max(100, ...samples.map(s => s.cpu_percent));
+        context.strokeStyle = '#3498db';
+        context.beginPath();
+        samples.forEach(function(sample, i) {
+            const x = samples.length === 1 ? canvas.width : i / (samples.length - 1) * canvas.width;
+            const y = canvas.height - sample.cpu_percent / peak * canvas.height;
+            if (i === 0) {
+                context.moveTo(x, y);
+            } else {
+                context.lineTo(x, y);
+            }
+        });
+        context.stroke();
+
+        const current = samples[samples.length - 1];
+        entry.row.querySelector('.usage-text').textContent =
+            `${current.cpu_percent.toFixed(1)}% CPU, ${formatBytes(current.memory_usage)}`;
+    }
+
+    function add(entry, sample) {
+        entry.samples.push(sample);
+        if (entry.samples.length > maxSamples) {
+            entry.samples.shift();
+        }
+        draw(entry);
+    }
+
+    // Load the recent history, then follow new samples
+    Promise.all(Object.values(rows).map(function(entry) {
+        return fetch(`/api/containers/${entry.row.dataset.containerId}/stats`)
+            .then(response => response.json())
+            .then(data => {
+                entry.samples = (data.history || []).slice(-maxSamples);
+                draw(entry);
+            })
+            .catch(() => {});
+    })).then(function() {
+        const source = new EventSource('/api/stats/stream');
+        source.addEventListener('stats', function(event) {
+            const data = JSON.parse(event.data);
+            const entry = rows[data.container];
+            if (!entry) {
+                return;
+            }
+            const samples = entry.samples;
+            if (samples.length === 0 || samples[samples.length - 1].time !== data.sample.time) {
+                add(entry, data.sample);
+            }
+        });
+    });
+});